@@ -0,0 +1,153 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+)
+
+type containerInfo struct {
+	Name         string          `json:"name"`
+	ImageChain   []string        `json:"image_chain"`
+	Booted       bool            `json:"booted"`
+	Config       json.RawMessage `json:"config"`
+	Version      string          `json:"version"`
+	Protocol     int64           `json:"protocol"`
+	DesktopFiles []string        `json:"desktop_files"`
+	Binaries     []string        `json:"binaries"`
+}
+
+func getContainerInfo() (*containerInfo, error) {
+	conn, err := varlinkConnect()
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	protocol, err := handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := protocol.require(devnsbox.CapabilityInfo, "container info"); err != nil {
+		return nil, err
+	}
+
+	info, err := devnsbox.GetInfo().Call(context.Background(), conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get container info")
+	}
+
+	// Hosts from before binaries were exported just leave them out.
+	desktopFiles, binaries, err := devnsbox.GetExports().Call(context.Background(), conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get container exports")
+	}
+
+	return &containerInfo{
+		Name:         info.Name,
+		ImageChain:   info.Image_chain,
+		Booted:       info.Booted,
+		Config:       info.Config,
+		Version:      info.Version,
+		Protocol:     protocol.version,
+		DesktopFiles: desktopFiles,
+		Binaries:     binaries,
+	}, nil
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	} else {
+		return "no"
+	}
+}
+
+func showContainerInfo(info *containerInfo) error {
+	var config container.Config
+	if err := json.Unmarshal(info.Config, &config); err != nil {
+		return errors.Wrap(err, "failed to parse container config")
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 1, ' ', tabwriter.AlignRight)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "Name:\t", info.Name)
+	fmt.Fprintln(writer, "Image:\t", strings.Join(info.ImageChain, " <- "))
+	fmt.Fprintln(writer, "Booted:\t", yesNo(info.Booted))
+	fmt.Fprintln(writer, "Network:\t", config.Network)
+	fmt.Fprintln(writer, "Private dirs:\t", strings.Join(config.PrivateDirs, ", "))
+	fmt.Fprintln(writer, "Host spawn:\t", yesNo(config.HostSpawn))
+	fmt.Fprintln(writer, "Desktop exports:\t", strings.Join(info.DesktopFiles, ", "))
+	fmt.Fprintln(writer, "Bin exports:\t", strings.Join(info.Binaries, ", "))
+	fmt.Fprintln(writer, "Host nsbox version:\t", info.Version)
+	fmt.Fprintln(writer, "Host protocol:\t", info.Protocol)
+
+	return nil
+}
+
+type infoCommand struct {
+	json bool
+}
+
+func newInfoCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &infoCommand{})
+}
+
+func (*infoCommand) Name() string {
+	return "info"
+}
+
+func (*infoCommand) Synopsis() string {
+	return "show information about this container"
+}
+
+func (*infoCommand) Usage() string {
+	return `info [-json]:
+	Show information about the container this is run in, as seen by the host. With -json, all
+	of it (including the full config) is printed as JSON instead.
+`
+}
+
+func (cmd *infoCommand) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.json, "json", false, "print the information as JSON")
+}
+
+func (cmd *infoCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs)
+}
+
+func (cmd *infoCommand) Execute(_ args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	info, err := getContainerInfo()
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	if !cmd.json {
+		return args.HandleError(showContainerInfo(info))
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return args.HandleError(errors.Wrap(err, "failed to marshal info"))
+	}
+
+	fmt.Println(string(data))
+	return subcommands.ExitSuccess
+}
@@ -7,6 +7,7 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
 
 	"github.com/google/subcommands"
 	"github.com/refi64/nsbox/internal/args"
@@ -22,15 +23,25 @@ func (app *nsboxHostApp) SetGlobalFlags(fs *flag.FlagSet)
 func main() {
 	app := &nsboxHostApp{}
 
+	// nsbox-init links xdg-open to nsbox-host in non-booted containers.
+	if filepath.Base(os.Args[0]) == "xdg-open" {
+		os.Args = append([]string{os.Args[0], "open"}, os.Args[1:]...)
+	}
+
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 
 	subcommands.Register(newReloadExportsCommand(app), "")
+	subcommands.Register(newSpawnCommand(app), "")
+	subcommands.Register(newOpenCommand(app), "")
+	subcommands.Register(newInfoCommand(app), "")
 
 	if os.Getenv(internalEnv) != "" {
 		subcommands.Register(newServiceCommand(app), "")
 		subcommands.Register(newEnterCommand(app), "")
+		subcommands.Register(newNotifyHookFailedCommand(app), "")
+		subcommands.Register(newNotifyStartFailedCommand(app), "")
 
 		os.Unsetenv(internalEnv)
 	}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"strconv"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+)
+
+func notifyHookFailed(hook, path string, status int64) error {
+	conn, err := varlinkConnect()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if err := devnsbox.NotifyHookFailed().Call(context.Background(), conn, hook, path, status); err != nil {
+		return errors.Wrap(err, "failed to send hook failed message")
+	}
+
+	return nil
+}
+
+type notifyHookFailedCommand struct {
+	hook   string
+	path   string
+	status string
+}
+
+func newNotifyHookFailedCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &notifyHookFailedCommand{})
+}
+
+func (*notifyHookFailedCommand) Name() string {
+	return "notify-hook-failed"
+}
+
+func (*notifyHookFailedCommand) Synopsis() string {
+	return "report a failed in-container hook to the host"
+}
+
+func (*notifyHookFailedCommand) Usage() string {
+	return ""
+}
+
+func (*notifyHookFailedCommand) SetFlags(fs *flag.FlagSet) {
+}
+
+func (cmd *notifyHookFailedCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.hook, &cmd.path, &cmd.status)
+}
+
+func (cmd *notifyHookFailedCommand) Execute(_ args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	status, err := strconv.ParseInt(cmd.status, 10, 64)
+	if err != nil {
+		return args.HandleError(errors.Wrap(err, "invalid hook status"))
+	}
+
+	return args.HandleError(notifyHookFailed(cmd.hook, cmd.path, status))
+}
@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/log"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+)
+
+func notifyStartFailed(stage, message, initLog string) error {
+	conn, err := varlinkConnect()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	protocol, err := handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	if !protocol.capabilities[devnsbox.CapabilityStartFailed] {
+		// Init already printed the failure, which is all an older host would've shown anyway.
+		log.Debug("host doesn't support NotifyStartFailed")
+		return nil
+	}
+
+	if err := devnsbox.NotifyStartFailed().Call(context.Background(), conn, stage, message, initLog); err != nil {
+		return errors.Wrap(err, "failed to send start failed message")
+	}
+
+	return nil
+}
+
+type notifyStartFailedCommand struct {
+	stage   string
+	message string
+	log     string
+}
+
+func newNotifyStartFailedCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &notifyStartFailedCommand{})
+}
+
+func (*notifyStartFailedCommand) Name() string {
+	return "notify-start-failed"
+}
+
+func (*notifyStartFailedCommand) Synopsis() string {
+	return "report a failed container init to the host"
+}
+
+func (*notifyStartFailedCommand) Usage() string {
+	return ""
+}
+
+func (*notifyStartFailedCommand) SetFlags(fs *flag.FlagSet) {
+}
+
+func (cmd *notifyStartFailedCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.stage, &cmd.message, &cmd.log)
+}
+
+func (cmd *notifyStartFailedCommand) Execute(_ args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	return args.HandleError(notifyStartFailed(cmd.stage, cmd.message, cmd.log))
+}
@@ -0,0 +1,95 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"path/filepath"
+	"regexp"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+)
+
+// Matches the start of anything that looks like a URI rather than a path.
+var uriSchemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+func openUri(target string) error {
+	uri := target
+	if !uriSchemeRe.MatchString(target) {
+		path, err := filepath.Abs(target)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve %s", target)
+		}
+
+		uri = (&url.URL{Scheme: "file", Path: path}).String()
+	}
+
+	conn, err := varlinkConnect()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	protocol, err := handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	if err := protocol.require(devnsbox.CapabilityOpenURI, "opening files and links on the host"); err != nil {
+		return err
+	}
+
+	err = devnsbox.OpenURI().Call(context.Background(), conn, uri)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *devnsbox.InvalidURI:
+		return errors.Errorf("cannot open %s: %s", target, err.Reason)
+	case *devnsbox.OpenFailed:
+		return errors.New(err.Reason)
+	}
+
+	return errors.Wrap(err, "failed to send open message")
+}
+
+type openCommand struct {
+	target string
+}
+
+func newOpenCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &openCommand{})
+}
+
+func (*openCommand) Name() string {
+	return "open"
+}
+
+func (*openCommand) Synopsis() string {
+	return "open a file or URL on the host"
+}
+
+func (*openCommand) Usage() string {
+	return `open <file | URL>:
+	Open a file or URL with the host's default application. This is also what runs when
+	xdg-open is used inside a container that isn't booted.
+`
+}
+
+func (*openCommand) SetFlags(fs *flag.FlagSet) {
+}
+
+func (cmd *openCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.target)
+}
+
+func (cmd *openCommand) Execute(_ args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	return args.HandleError(openUri(cmd.target))
+}
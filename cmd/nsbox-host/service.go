@@ -14,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/ptyservice"
+	"github.com/refi64/nsbox/internal/sessionkeeper"
 	devnsbox "github.com/refi64/nsbox/internal/varlink"
 )
 
@@ -22,6 +23,10 @@ func startPtyServiceAndNotifyHost(name string) error {
 		return errors.Wrap(err, "failed to start pty service")
 	}
 
+	if err := sessionkeeper.StartSessionKeeper(); err != nil {
+		return errors.Wrap(err, "failed to start session keeper")
+	}
+
 	conn, err := varlinkConnect()
 	if err != nil {
 		return err
@@ -59,7 +64,7 @@ func (*serviceCommand) Name() string {
 }
 
 func (*serviceCommand) Synopsis() string {
-	return "starts the PTY service"
+	return "starts the PTY service and session keeper"
 }
 
 func (*serviceCommand) Usage() string {
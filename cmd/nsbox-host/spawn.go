@@ -0,0 +1,238 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/creack/pty"
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/refi64/nsbox/internal/varlinkpeer"
+	"github.com/varlink/go/varlink"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// The signals that would reach the command if it were run directly. With a tty, the terminal
+// itself sends the rest.
+var spawnForwardedSignals = []os.Signal{unix.SIGHUP, unix.SIGINT, unix.SIGQUIT, unix.SIGTERM,
+	unix.SIGUSR1, unix.SIGUSR2}
+
+// Forwards signals to the spawned command until the returned function is called.
+func forwardSpawnSignals(id int64) func() {
+	signals := make(chan os.Signal, 16)
+	done := make(chan struct{})
+	signal.Notify(signals, spawnForwardedSignals...)
+
+	go func() {
+		var conn *varlink.Connection
+
+		for {
+			select {
+			case <-done:
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			case sig := <-signals:
+				if conn == nil {
+					var err error
+					conn, err = varlinkConnect()
+					if err != nil {
+						log.Debug("failed to connect to forward signal:", err)
+						continue
+					}
+				}
+
+				err := devnsbox.SignalSpawned().Call(context.Background(), conn, id, int64(sig.(unix.Signal)))
+				if err != nil {
+					log.Debugf("failed to forward %v: %v", sig, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Copies between this terminal and the spawned command's, returning a channel that's closed
+// once all of its output has been written.
+func proxyTerminal(master *os.File) <-chan struct{} {
+	pty.InheritSize(os.Stdin, master)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+
+	go func() {
+		for range winch {
+			pty.InheritSize(os.Stdin, master)
+		}
+	}()
+
+	go io.Copy(master, os.Stdin)
+
+	outputDone := make(chan struct{})
+	go func() {
+		// This ends with EIO once the command's side of the terminal is closed.
+		io.Copy(os.Stdout, master)
+		signal.Stop(winch)
+		close(outputDone)
+	}()
+
+	return outputDone
+}
+
+func spawn(command []string) (int, error) {
+	if err := requireHostCapability(devnsbox.CapabilitySpawn, "running host commands"); err != nil {
+		return 0, err
+	}
+
+	client, err := varlinkpeer.Dial("/run/host/nsbox/" + paths.HostServiceSocketName)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to connect to host socket")
+	}
+
+	defer client.Close()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get working directory")
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+	tty := terminal.IsTerminal(stdinFd) && terminal.IsTerminal(int(os.Stdout.Fd()))
+
+	// The generated client can't send files, so the call goes through varlinkpeer instead.
+	parameters := struct {
+		Command []string `json:"command"`
+		Environ []string `json:"environ"`
+		Cwd     string   `json:"cwd"`
+		Tty     bool     `json:"tty"`
+	}{Command: command, Environ: os.Environ(), Cwd: cwd, Tty: tty}
+
+	if err := client.Send("dev.nsbox.Spawn", parameters, true, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		return 0, errors.Wrap(err, "failed to send spawn request")
+	}
+
+	stopForwarding := func() {}
+	defer func() { stopForwarding() }()
+
+	var outputDone <-chan struct{}
+	started := false
+
+	for {
+		var out struct {
+			Id     int64 `json:"id"`
+			Status int64 `json:"status"`
+		}
+
+		continues, err := client.Receive(&out)
+		if varlinkErr, ok := err.(*varlink.Error); ok {
+			err := devnsbox.Dispatch_Error(varlinkErr.DispatchError())
+			switch err := err.(type) {
+			case *devnsbox.SpawnDisabled:
+				return 0, errors.New("this container isn't allowed to run host commands (see the host-spawn config option)")
+			case *devnsbox.SpawnFailed:
+				return 0, errors.New(err.Reason)
+			}
+
+			return 0, errors.Wrap(err, "failed to spawn command")
+		} else if err != nil {
+			return 0, errors.Wrap(err, "lost connection to host")
+		}
+
+		if !continues {
+			if outputDone != nil {
+				<-outputDone
+			}
+
+			return int(out.Status), nil
+		}
+
+		if started {
+			continue
+		}
+
+		started = true
+		stopForwarding = forwardSpawnSignals(out.Id)
+
+		if tty {
+			files := client.TakeFiles()
+			if len(files) != 1 {
+				varlinkpeer.CloseFiles(files)
+				return 0, errors.New("host did not send a terminal")
+			}
+
+			master := files[0]
+			defer master.Close()
+
+			state, err := terminal.MakeRaw(stdinFd)
+			if err != nil {
+				log.Debug("failed to make terminal raw:", err)
+			} else {
+				defer terminal.Restore(stdinFd, state)
+			}
+
+			outputDone = proxyTerminal(master)
+		}
+	}
+}
+
+type spawnCommand struct {
+	command []string
+}
+
+func newSpawnCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &spawnCommand{})
+}
+
+func (*spawnCommand) Name() string {
+	return "spawn"
+}
+
+func (*spawnCommand) Synopsis() string {
+	return "run a command on the host"
+}
+
+func (*spawnCommand) Usage() string {
+	return `spawn -- <command...>:
+	Run a command on the host as the container's owner, in the host directory corresponding
+	to the current one. The container's host-spawn option must be enabled.
+`
+}
+
+func (*spawnCommand) SetFlags(fs *flag.FlagSet) {
+}
+
+func (cmd *spawnCommand) ParsePositional(fs *flag.FlagSet) error {
+	if fs.NArg() == 0 {
+		return errors.New("expected a command")
+	}
+
+	cmd.command = fs.Args()
+	return nil
+}
+
+func (cmd *spawnCommand) Execute(_ args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	status, err := spawn(cmd.command)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	os.Exit(status)
+	return subcommands.ExitSuccess
+}
@@ -8,7 +8,9 @@ import (
 	"context"
 
 	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
 	"github.com/refi64/nsbox/internal/paths"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
 	"github.com/varlink/go/varlink"
 )
 
@@ -20,3 +22,73 @@ func varlinkConnect() (*varlink.Connection, error) {
 
 	return conn, nil
 }
+
+// Checks if the host replied that it doesn't have the called method at all.
+func isMethodNotFound(err error) bool {
+	switch err := err.(type) {
+	case *varlink.MethodNotFound:
+		return true
+	case *varlink.Error:
+		// The reply's parameters couldn't be parsed, but the error name is still there.
+		return err.Name == "org.varlink.service.MethodNotFound"
+	}
+
+	return false
+}
+
+// What the host's side of the dev.nsbox protocol supports.
+type hostProtocol struct {
+	version      int64
+	capabilities map[string]bool
+}
+
+func handshake(conn *varlink.Connection) (*hostProtocol, error) {
+	version, capabilities, err := devnsbox.Handshake().Call(context.Background(), conn, devnsbox.ProtocolVersion)
+	if err != nil {
+		if isMethodNotFound(err) {
+			// The host's nsbox predates Handshake.
+			return &hostProtocol{version: 1}, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to handshake with host")
+	}
+
+	protocol := &hostProtocol{version: version, capabilities: map[string]bool{}}
+	for _, capability := range capabilities {
+		protocol.capabilities[capability] = true
+	}
+
+	if version != devnsbox.ProtocolVersion {
+		log.Debugf("host speaks protocol %d, we speak %d", version, devnsbox.ProtocolVersion)
+	}
+
+	return protocol, nil
+}
+
+// Fails if the host doesn't support the given capability, which is needed for feature.
+func (protocol *hostProtocol) require(capability, feature string) error {
+	if !protocol.capabilities[capability] {
+		return errors.Errorf("the host's nsbox (protocol %d) is too old to support %s; update nsbox on the host",
+			protocol.version, feature)
+	}
+
+	return nil
+}
+
+// Like hostProtocol.require, but over its own connection, for callers that don't use a
+// varlink.Connection for the rest of their calls.
+func requireHostCapability(capability, feature string) error {
+	conn, err := varlinkConnect()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	protocol, err := handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	return protocol.require(capability, feature)
+}
@@ -0,0 +1,138 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/varlink/go/varlink"
+)
+
+// Connects to a fake host that gives the same reply to every call.
+func connectFakeHost(t *testing.T, reply string) *varlink.Connection {
+	dir, err := ioutil.TempDir("", "nsbox-host-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			if _, err := reader.ReadBytes(0); err != nil {
+				return
+			}
+
+			if _, err := conn.Write(append([]byte(reply), 0)); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := varlink.NewConnection(context.Background(), "unix:"+socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		reply   string
+		version int64
+		// Whether the host should be allowed to spawn commands.
+		canSpawn bool
+	}{
+		{
+			name:    "before handshake",
+			reply:   `{"error":"org.varlink.service.MethodNotFound","parameters":{"method":"Handshake"}}`,
+			version: 1,
+		},
+		{
+			name:    "before handshake, without parameters",
+			reply:   `{"error":"org.varlink.service.MethodNotFound"}`,
+			version: 1,
+		},
+		{
+			name:    "older host",
+			reply:   `{"parameters":{"protocol":2,"capabilities":["start-failed"]}}`,
+			version: 2,
+		},
+		{
+			name:     "same host",
+			reply:    `{"parameters":{"protocol":2,"capabilities":["start-failed","spawn","open-uri","info"]}}`,
+			version:  2,
+			canSpawn: true,
+		},
+		{
+			name:     "newer host",
+			reply:    `{"parameters":{"protocol":3,"capabilities":["spawn","from-the-future"]}}`,
+			version:  3,
+			canSpawn: true,
+		},
+	} {
+		conn := connectFakeHost(t, test.reply)
+
+		protocol, err := handshake(conn)
+		conn.Close()
+
+		if err != nil {
+			t.Errorf("%s: handshake failed: %v", test.name, err)
+			continue
+		}
+
+		if protocol.version != test.version {
+			t.Errorf("%s: expected protocol %d, got %d", test.name, test.version, protocol.version)
+		}
+
+		err = protocol.require(devnsbox.CapabilitySpawn, "running host commands")
+		if test.canSpawn {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected spawning to be unsupported", test.name)
+		} else if !strings.Contains(err.Error(), "too old to support running host commands") ||
+			!strings.Contains(err.Error(), "update nsbox on the host") {
+			t.Errorf("%s: unclear error: %v", test.name, err)
+		}
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	// Anything other than a missing method is a real failure, not an old host.
+	conn := connectFakeHost(t, `{"error":"org.varlink.service.InvalidParameter","parameters":{"parameter":"client_protocol"}}`)
+	defer conn.Close()
+
+	if _, err := handshake(conn); err == nil || !strings.Contains(err.Error(), "failed to handshake with host") {
+		t.Errorf("expected a handshake failure, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/manager"
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	if os.Getuid() != 0 {
+		log.Fatal("nsbox-manager must be run as root")
+	}
+
+	if _, err := manager.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Debug("serving", manager.BusName)
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, unix.SIGINT, unix.SIGTERM)
+	<-sigchan
+}
@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/session"
+)
+
+type attachCommand struct {
+	container string
+	session   string
+}
+
+func newAttachCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &attachCommand{})
+}
+
+func (*attachCommand) Name() string {
+	return "attach"
+}
+
+func (*attachCommand) Synopsis() string {
+	return "attach to a detached session"
+}
+
+func (*attachCommand) Usage() string {
+	return `attach <container> <session>
+	Attach to a session started via 'nsbox run -detach', replaying its recent output. Press
+	Ctrl-] to detach again, leaving the session running.
+`
+}
+
+func (*attachCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *attachCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container, &cmd.session)
+}
+
+func (cmd *attachCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	exitCode, err := session.AttachSession(ct, cmd.session)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	os.Exit(exitCode)
+	return subcommands.ExitSuccess
+}
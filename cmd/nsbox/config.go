@@ -5,13 +5,16 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 
 	"github.com/google/subcommands"
+	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
 	"github.com/refi64/nsbox/internal/integration"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -19,6 +22,8 @@ import (
 type configCommand struct {
 	name string
 
+	binExports        args.ArrayTransformValue
+	egressAllow       args.ArrayTransformValue
 	extraBindMounts   args.ArrayTransformValue
 	extraCapabilities args.ArrayTransformValue
 	privateDirs       args.ArrayTransformValue
@@ -27,7 +32,18 @@ type configCommand struct {
 	xdgDesktopExtra   args.ArrayTransformValue
 	xdgDesktopExports args.ArrayTransformValue
 	auth              container.Auth
+	egress            container.EgressPolicy
+	network           container.NetworkMode
+	restart           container.RestartPolicy
+	idleTimeout       container.Duration
+	networkInterface  string
+	postStartHook     string
+	postStopHook      string
+	preStartHook      string
+	sharedNetwork     string
 	shareCgroupfs     bool
+	recordSessions    bool
+	hostSpawn         bool
 	virtualNetwork    bool
 }
 
@@ -52,8 +68,20 @@ func (*configCommand) Usage() string {
 
 func (cmd *configCommand) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&cmd.shareCgroupfs, "share-cgroupfs", false, "share the host's cgroupfs")
-	fs.BoolVar(&cmd.virtualNetwork, "virtual-network", false, "use a virtualized network")
+	fs.BoolVar(&cmd.recordSessions, "record-sessions", false, "record every terminal session in the container")
+	fs.BoolVar(&cmd.hostSpawn, "host-spawn", false, "let the container run commands on the host via nsbox-host spawn")
+	fs.BoolVar(&cmd.virtualNetwork, "virtual-network", false, "use a virtualized network (deprecated, use -network=veth)")
 	fs.Var(&cmd.auth, "auth", "password authentication method")
+	fs.Var(&cmd.network, "network", "network mode (host, none, veth, bridge, macvlan, ipvlan)")
+	fs.StringVar(&cmd.networkInterface, "network-interface", "", "host interface for bridge, macvlan, and ipvlan networks")
+	fs.StringVar(&cmd.sharedNetwork, "shared-network", "", "shared network to join for veth networks")
+	fs.Var(&cmd.egress, "egress", "egress policy for veth networks (allow-all, deny-all, allow-list)")
+	fs.Var(&cmd.egressAllow, "egress-allow", "hosts, CIDRs, and ports allowed by the allow-list egress policy")
+	fs.StringVar(&cmd.preStartHook, "pre-start-hook", "", "host executable to run before the container starts")
+	fs.StringVar(&cmd.postStartHook, "post-start-hook", "", "host executable to run after the container starts")
+	fs.StringVar(&cmd.postStopHook, "post-stop-hook", "", "host executable to run after the container stops")
+	fs.Var(&cmd.restart, "restart", "restart policy when the container fails (no, on-failure)")
+	fs.Var(&cmd.idleTimeout, "idle-timeout", "stop the container after it has no sessions for this long (0 disables)")
 	fs.Var(&cmd.extraBindMounts, "extra-bind-mounts", "extra bind mounts")
 	fs.Var(&cmd.extraCapabilities, "extra-capabilities", "extra capabilities to grant")
 	fs.Var(&cmd.privateDirs, "private-dirs", "paths under home that will be private to the container")
@@ -61,22 +89,39 @@ func (cmd *configCommand) SetFlags(fs *flag.FlagSet) {
 	fs.Var(&cmd.syscallFilters, "syscall-filters", "system call filters")
 	fs.Var(&cmd.xdgDesktopExtra, "xdg-desktop-extra", "extra desktop file directories")
 	fs.Var(&cmd.xdgDesktopExports, "xdg-desktop-exports", "exported desktop files patterns")
+	fs.Var(&cmd.binExports, "bin-exports", "binaries to export as host commands (names, paths, or patterns)")
 }
 
 func (cmd *configCommand) ParsePositional(fs *flag.FlagSet) error {
 	return args.ExpectArgs(fs, &cmd.name)
 }
 
+func showConfig(ct *container.Container) error {
+	data, err := json.MarshalIndent(ct.Config, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
 func (cmd *configCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
 	ct, err := container.Open(app.(*nsboxApp).usrdata, cmd.name)
 	if err != nil {
 		return args.HandleError(err)
 	}
 
+	if fs.NFlag() == 0 {
+		return args.HandleError(showConfig(ct))
+	}
+
 	if err := ct.LockUntilProcessDeath(container.ConfigLock, container.NoWaitForLock); err != nil {
 		return args.HandleError(err)
 	}
 
+	given := map[string]bool{}
+
 	fs.Visit(func(f *flag.Flag) {
 		// XXX: This is ridiculous, all I want to know is if flags were actually given...
 		if f.Name == "auth" {
@@ -98,17 +143,60 @@ func (cmd *configCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.Ex
 					return
 				}
 			}
+		} else if f.Name == "egress" {
+			ct.Config.Egress = cmd.egress
 		} else if f.Name == "share-cgroupfs" {
 			ct.Config.ShareCgroupfs = cmd.shareCgroupfs
+		} else if f.Name == "record-sessions" {
+			ct.Config.RecordSessions = cmd.recordSessions
+		} else if f.Name == "host-spawn" {
+			ct.Config.HostSpawn = cmd.hostSpawn
 		} else if f.Name == "virtual-network" {
-			ct.Config.VirtualNetwork = cmd.virtualNetwork
+			if cmd.virtualNetwork {
+				ct.Config.Network = container.NetworkVeth
+			} else {
+				ct.Config.Network = container.NetworkHost
+			}
+		} else if f.Name == "network" {
+			ct.Config.Network = cmd.network
+		} else if f.Name == "network-interface" {
+			ct.Config.NetworkInterface = cmd.networkInterface
+		} else if f.Name == "pre-start-hook" {
+			ct.Config.PreStartHook = cmd.preStartHook
+		} else if f.Name == "post-start-hook" {
+			ct.Config.PostStartHook = cmd.postStartHook
+		} else if f.Name == "post-stop-hook" {
+			ct.Config.PostStopHook = cmd.postStopHook
+		} else if f.Name == "restart" {
+			ct.Config.Restart = cmd.restart
+		} else if f.Name == "idle-timeout" {
+			ct.Config.IdleTimeout = cmd.idleTimeout
+		} else if f.Name == "shared-network" {
+			ct.Config.SharedNetwork = cmd.sharedNetwork
 		}
+
+		given[f.Name] = true
 	})
 
 	if err != nil {
 		return args.HandleError(err)
 	}
 
+	// Changing the network mode drops the settings that no longer apply to it, unless they were
+	// also given explicitly. This has to happen after Visit, since it goes in lexicographical
+	// order and -virtual-network comes after -network-interface and -shared-network.
+	if given["network"] || given["virtual-network"] {
+		if !ct.Config.Network.NeedsInterface() && !given["network-interface"] {
+			ct.Config.NetworkInterface = ""
+		}
+
+		if ct.Config.Network != container.NetworkVeth && !given["shared-network"] {
+			ct.Config.SharedNetwork = ""
+		}
+	}
+
+	cmd.binExports.Apply(&ct.Config.BinExports)
+	cmd.egressAllow.Apply(&ct.Config.EgressAllow)
 	cmd.extraBindMounts.Apply(&ct.Config.ExtraBindMounts)
 	cmd.extraCapabilities.Apply(&ct.Config.ExtraCapabilities)
 	cmd.privateDirs.Apply(&ct.Config.PrivateDirs)
@@ -117,9 +205,17 @@ func (cmd *configCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.Ex
 	cmd.xdgDesktopExtra.Apply(&ct.Config.XdgDesktopExtra)
 	cmd.xdgDesktopExports.Apply(&ct.Config.XdgDesktopExports)
 
+	if err := checkSharedNetwork(app.(*nsboxApp).usrdata, ct); err != nil {
+		return args.HandleError(err)
+	}
+
 	if err := ct.UpdateConfig(); err != nil {
 		return args.HandleError(err)
 	}
 
+	if err := daemon.UpdateEnabledContainer(ct, app.(*nsboxApp).usrdata); err != nil {
+		return args.HandleError(err)
+	}
+
 	return args.HandleError(integration.UpdateDesktopFiles(ct))
 }
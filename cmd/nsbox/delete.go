@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
 	"github.com/refi64/nsbox/internal/inventory"
 )
 
@@ -72,5 +73,16 @@ func (cmd *deleteCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.Ex
 		}
 	}
 
+	enabled, err := daemon.IsContainerEnabled(ct, app.(*nsboxApp).usrdata)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	if enabled {
+		if err := daemon.DisableContainer(ct, app.(*nsboxApp).usrdata); err != nil {
+			return args.HandleError(err)
+		}
+	}
+
 	return args.HandleError(ct.LockAndDelete(container.NoWaitForLock))
 }
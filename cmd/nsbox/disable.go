@@ -0,0 +1,54 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
+)
+
+type disableCommand struct {
+	container string
+}
+
+func newDisableCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &disableCommand{})
+}
+
+func (*disableCommand) Name() string {
+	return "disable"
+}
+
+func (*disableCommand) Synopsis() string {
+	return "stop starting a container on boot"
+}
+
+func (*disableCommand) Usage() string {
+	return `disable <container>
+	Remove the container's persistent systemd unit. If the container is running, it will be left
+	running.
+`
+}
+
+func (*disableCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *disableCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *disableCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(daemon.DisableContainer(ct, usrdata))
+}
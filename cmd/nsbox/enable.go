@@ -0,0 +1,57 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
+)
+
+type enableCommand struct {
+	container string
+	now       bool
+}
+
+func newEnableCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &enableCommand{})
+}
+
+func (*enableCommand) Name() string {
+	return "enable"
+}
+
+func (*enableCommand) Synopsis() string {
+	return "start a container on boot"
+}
+
+func (*enableCommand) Usage() string {
+	return `enable [-now] <container>
+	Install a persistent systemd unit for the container, so it will be started automatically on
+	boot.
+`
+}
+
+func (cmd *enableCommand) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.now, "now", false, "Also start the container now")
+}
+
+func (cmd *enableCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *enableCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(daemon.EnableContainer(ct, usrdata, cmd.now))
+}
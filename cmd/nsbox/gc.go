@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/network"
+	"github.com/refi64/nsbox/internal/nspawn"
+)
+
+type gcCommand struct {
+}
+
+func newGcCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &gcCommand{})
+}
+
+func (*gcCommand) Name() string {
+	return "gc"
+}
+
+func (*gcCommand) Synopsis() string {
+	return "clean up stale container resources"
+}
+
+func (*gcCommand) Usage() string {
+	return `gc
+	Clean up any resources left behind by containers that did not exit cleanly, such as firewall
+	rules for virtual networks that no longer exist.
+`
+}
+
+func (*gcCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *gcCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs)
+}
+
+func pruneFirewall() error {
+	firewall := network.GetFirewall()
+	if firewall == nil {
+		log.Debug("No firewall to prune")
+		return nil
+	}
+
+	defer func() {
+		if err := firewall.Close(); err != nil {
+			log.Alert("Failed to close firewall:", err)
+		}
+	}()
+
+	return errors.Wrap(firewall.Prune(nspawn.NetworkZonePrefix), "pruning firewall")
+}
+
+func (cmd *gcCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	return args.HandleError(pruneFirewall())
+}
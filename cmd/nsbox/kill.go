@@ -6,17 +6,21 @@ package main
 
 import (
 	"flag"
+	"time"
 
 	"github.com/google/subcommands"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/container"
 	"github.com/refi64/nsbox/internal/kill"
+	"github.com/refi64/nsbox/internal/log"
 )
 
 type killCommand struct {
-	container string
-	signal    kill.Signal
-	all       bool
+	container    string
+	signal       kill.Signal
+	all          bool
+	allProcesses bool
+	timeout      time.Duration
 }
 
 func newKillCommand(app args.App) subcommands.Command {
@@ -32,29 +36,50 @@ func (*killCommand) Synopsis() string {
 }
 
 func (*killCommand) Usage() string {
-	return `kill [-signal signal] [-all] <container>:
-	Kill the container using the given signal. If the signal is not given, then poweroff is the
-	default for booted containers, and sigterm is the default for non-booted containers.
+	return `kill [-signal signal] [-all-processes] [-timeout duration] <container>
+kill [-signal signal] [-all-processes] [-timeout duration] -all:
+	Kill the container using the given signal, which may be poweroff or any signal name (e.g.
+	sigterm, term, or hup). If the signal is not given, then poweroff is the default. If the
+	signal should make the container exit, then this waits up to the timeout for it to do so.
+	If -all is given, then every running container is killed. With -all-processes, the signal
+	is sent to every process in the container, rather than just its leader. (Passing -all with a
+	container is a deprecated spelling of -all-processes.)
 `
 }
 
 func (cmd *killCommand) SetFlags(fs *flag.FlagSet) {
 	fs.Var(&cmd.signal, "signal", "The signal to use to kill the container")
-	fs.BoolVar(&cmd.all, "all", false, "Send the signal to all processes, not just the leader")
+	fs.BoolVar(&cmd.all, "all", false, "Kill all running containers")
+	fs.BoolVar(&cmd.allProcesses, "all-processes", false, "Send the signal to all processes, not just the leader")
+	fs.DurationVar(&cmd.timeout, "timeout", kill.DefaultTimeout, "How long to wait for the container to exit")
 }
 
 func (cmd *killCommand) ParsePositional(fs *flag.FlagSet) error {
+	// -all with a container is the old spelling of -all-processes, handled in Execute.
+	if cmd.all && fs.NArg() != 1 {
+		return args.ExpectArgs(fs)
+	}
+
 	return args.ExpectArgs(fs, &cmd.container)
 }
 
 func (cmd *killCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
 	usrdata := app.(*nsboxApp).usrdata
 
+	if cmd.all && cmd.container != "" {
+		log.Alert("WARNING: kill -all with a container is deprecated, use -all-processes instead")
+		cmd.allProcesses = true
+	} else if cmd.all {
+		return args.HandleError(forAllRunningContainers(usrdata, "kill", func(ct *container.Container) error {
+			return kill.KillContainer(usrdata, ct, cmd.signal, cmd.allProcesses, cmd.timeout)
+		}))
+	}
+
 	ct, err := container.Open(usrdata, cmd.container)
 	if err != nil {
 		return args.HandleError(err)
 	}
 
-	err = kill.KillContainer(app.(*nsboxApp).usrdata, ct, cmd.signal, cmd.all)
+	err = kill.KillContainer(usrdata, ct, cmd.signal, cmd.allProcesses, cmd.timeout)
 	return args.HandleError(err)
 }
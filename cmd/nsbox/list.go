@@ -72,7 +72,16 @@ func (cmd *listCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.Exit
 			}
 		}
 
-		log.Info(ct.Name)
+		paused, err := ct.IsPaused(app.(*nsboxApp).usrdata)
+		if err != nil {
+			log.Debugf("Failed to get paused state of %s: %v", ct.Name, err)
+		}
+
+		if paused {
+			log.Info(ct.Name, "(paused)")
+		} else {
+			log.Info(ct.Name)
+		}
 	}
 
 	return subcommands.ExitSuccess
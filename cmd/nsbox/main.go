@@ -13,6 +13,7 @@ import (
 	"github.com/google/subcommands"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/config"
+	"github.com/refi64/nsbox/internal/inventory"
 	"github.com/refi64/nsbox/internal/log"
 	"github.com/refi64/nsbox/internal/paths"
 	"github.com/refi64/nsbox/internal/userdata"
@@ -25,7 +26,7 @@ type nsboxApp struct {
 	workdir string
 }
 
-func createStateDirectory() {
+func createStateDirectory(usrdata *userdata.Userdata) {
 	if err := os.MkdirAll(paths.StorageRoot, 0755); err != nil && !os.IsExist(err) {
 		log.Fatal("failed to create state directory:", err)
 	}
@@ -33,10 +34,28 @@ func createStateDirectory() {
 	if err := os.Chmod(paths.StorageRoot, 0755); err != nil {
 		log.Fatal("failed to chmod state directory:", err)
 	}
+
+	if err := inventory.PrepareUserStorage(usrdata); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Returns true if the command only reads the user's own containers, in which case it can run
+// without root as long as the user's storage is readable.
+func commandOnlyReadsInventory(cmd subcommands.Command, fs *flag.FlagSet) bool {
+	switch cmd.Name() {
+	case "info", "list":
+		return true
+	case "config":
+		// Without any options, config only shows the current config.
+		return fs.NFlag() == 0
+	default:
+		return false
+	}
 }
 
 func commandNeedsRoot(cmd subcommands.Command) bool {
-	return cmd.Name() != "version"
+	return cmd.Name() != "version" && cmd.Name() != "images"
 }
 
 func (app *nsboxApp) privilegedReexec(cmd subcommands.Command, fs *flag.FlagSet) {
@@ -95,7 +114,13 @@ func (app *nsboxApp) privilegedReexec(cmd subcommands.Command, fs *flag.FlagSet)
 
 func (app *nsboxApp) PreexecHook(cmd subcommands.Command, fs *flag.FlagSet) {
 	if os.Getuid() == 0 {
-		createStateDirectory()
+		createStateDirectory(app.usrdata)
+	} else if commandOnlyReadsInventory(cmd, fs) {
+		// If the storage isn't readable (e.g. the user's primary group changed), fall back to
+		// reading it as root.
+		if !inventory.CanReadUserStorage(app.usrdata) {
+			app.privilegedReexec(cmd, fs)
+		}
 	} else if commandNeedsRoot(cmd) {
 		app.privilegedReexec(cmd, fs)
 	}
@@ -137,16 +162,28 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
+	subcommands.Register(newAttachCommand(app), "")
 	subcommands.Register(newConfigCommand(app), "")
 	subcommands.Register(newCreateCommand(app), "")
 	subcommands.Register(newDeleteCommand(app), "")
+	subcommands.Register(newDisableCommand(app), "")
+	subcommands.Register(newEnableCommand(app), "")
+	subcommands.Register(newGcCommand(app), "")
 	subcommands.Register(newImagesCommand(app), "")
 	subcommands.Register(newInfoCommand(app), "")
 	subcommands.Register(newKillCommand(app), "")
 	subcommands.Register(newListCommand(app), "")
+	subcommands.Register(newNetworkCommand(app), "")
+	subcommands.Register(newPauseCommand(app), "")
+	subcommands.Register(newPsCommand(app), "")
+	subcommands.Register(newRecordingsCommand(app), "")
 	subcommands.Register(newRenameCommand(app), "")
+	subcommands.Register(newResumeCommand(app), "")
 	subcommands.Register(newRunCommand(app), "")
+	subcommands.Register(newSessionsCommand(app), "")
 	subcommands.Register(newSetDefaultCommand(app), "")
+	subcommands.Register(newStartCommand(app), "")
+	subcommands.Register(newStopCommand(app), "")
 	subcommands.Register(newVersionCommand(app), "")
 
 	args.Execute(app)
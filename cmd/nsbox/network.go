@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/inventory"
+	"github.com/refi64/nsbox/internal/network"
+	"github.com/refi64/nsbox/internal/nspawn"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+type networkCommand struct {
+	action string
+	name   string
+}
+
+func newNetworkCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &networkCommand{})
+}
+
+func (*networkCommand) Name() string {
+	return "network"
+}
+
+func (*networkCommand) Synopsis() string {
+	return "manage shared networks"
+}
+
+func (*networkCommand) Usage() string {
+	return `network create <network> | ls | rm <network>
+	Manages named virtual networks that can be shared between several booted containers. Use
+	'config -network=veth -shared-network=<network>' to have a container join one.
+`
+}
+
+func (*networkCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *networkCommand) ParsePositional(fs *flag.FlagSet) error {
+	cmd.action = fs.Arg(0)
+
+	switch cmd.action {
+	case "create", "rm":
+		if fs.NArg() != 2 {
+			return errors.Errorf("expected 2 arg(s), got %d", fs.NArg())
+		}
+
+		cmd.name = fs.Arg(1)
+	case "ls":
+		if fs.NArg() != 1 {
+			return errors.Errorf("expected 1 arg(s), got %d", fs.NArg())
+		}
+	default:
+		return errors.Errorf("unknown network action: %s", cmd.action)
+	}
+
+	return nil
+}
+
+func networkMembers(usrdata *userdata.Userdata, name string) ([]string, error) {
+	containers, err := inventory.List(usrdata)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []string{}
+	for _, ct := range containers {
+		if ct.Config.SharedNetwork == name {
+			members = append(members, ct.Name)
+		}
+	}
+
+	return members, nil
+}
+
+func listNetworks(usrdata *userdata.Userdata) error {
+	networks, err := network.ListShared(usrdata)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+
+	for _, net := range networks {
+		members, err := networkMembers(usrdata, net.Name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(writer, "%s\t%s%s\t%s\n", net.Name, nspawn.NetworkZonePrefix,
+			net.ZoneName(nspawn.NetworkZonePrefix), strings.Join(members, ", "))
+	}
+
+	return nil
+}
+
+func removeNetwork(usrdata *userdata.Userdata, name string) error {
+	net, err := network.OpenShared(usrdata, name)
+	if err != nil {
+		return err
+	}
+
+	members, err := networkMembers(usrdata, name)
+	if err != nil {
+		return err
+	}
+
+	if len(members) != 0 {
+		return errors.Errorf("network %s is still used by: %s", name, strings.Join(members, ", "))
+	}
+
+	return net.Remove(usrdata)
+}
+
+func (cmd *networkCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	switch cmd.action {
+	case "create":
+		_, err := network.CreateShared(usrdata, cmd.name)
+		return args.HandleError(err)
+	case "ls":
+		return args.HandleError(listNetworks(usrdata))
+	case "rm":
+		return args.HandleError(removeNetwork(usrdata, cmd.name))
+	}
+
+	panic("unexpected network action " + cmd.action)
+}
+
+// Makes sure a container's shared network exists before it's saved into the config.
+func checkSharedNetwork(usrdata *userdata.Userdata, ct *container.Container) error {
+	if ct.Config.SharedNetwork == "" {
+		return nil
+	}
+
+	_, err := network.OpenShared(usrdata, ct.Config.SharedNetwork)
+	return err
+}
@@ -0,0 +1,53 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+)
+
+type pauseCommand struct {
+	container string
+}
+
+func newPauseCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &pauseCommand{})
+}
+
+func (*pauseCommand) Name() string {
+	return "pause"
+}
+
+func (*pauseCommand) Synopsis() string {
+	return "pause a running container"
+}
+
+func (*pauseCommand) Usage() string {
+	return `pause <container>
+	Freeze every process inside the container, keeping its state in memory until it's
+	resumed via 'nsbox resume'. Running the container will also resume it.
+`
+}
+
+func (*pauseCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *pauseCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *pauseCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(ct.Pause(usrdata))
+}
@@ -0,0 +1,53 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+)
+
+type psCommand struct {
+	container string
+}
+
+func newPsCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &psCommand{})
+}
+
+func (*psCommand) Name() string {
+	return "ps"
+}
+
+func (*psCommand) Synopsis() string {
+	return "list the processes running inside a container"
+}
+
+func (*psCommand) Usage() string {
+	return `ps <container>
+	List every process inside the running container, with its PID on the host and inside the
+	container. Sessions started via 'nsbox run' show their working directory.
+`
+}
+
+func (*psCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *psCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *psCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(ct.ShowProcesses(usrdata))
+}
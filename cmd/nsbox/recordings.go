@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/session"
+)
+
+type recordingsCommand struct {
+	container string
+	olderThan time.Duration
+	keep      int
+}
+
+func newRecordingsCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &recordingsCommand{})
+}
+
+func (*recordingsCommand) Name() string {
+	return "recordings"
+}
+
+func (*recordingsCommand) Synopsis() string {
+	return "list and prune a container's session recordings"
+}
+
+func (*recordingsCommand) Usage() string {
+	return `recordings [-prune-older-than=<duration>] [-keep=<count>] <container>
+	List the sessions recorded because of the container's record-sessions option. If
+	-prune-older-than or -keep is given, recordings older than the duration, or past the
+	newest <count> ones, are deleted instead.
+`
+}
+
+func (cmd *recordingsCommand) SetFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&cmd.olderThan, "prune-older-than", 0, "delete recordings older than this (e.g. 168h)")
+	fs.IntVar(&cmd.keep, "keep", -1, "delete all but this many of the newest recordings")
+}
+
+func (cmd *recordingsCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *recordingsCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	ct, err := container.Open(app.(*nsboxApp).usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	if cmd.olderThan != 0 || cmd.keep >= 0 {
+		return args.HandleError(session.PruneRecordings(ct, cmd.olderThan, cmd.keep))
+	}
+
+	return args.HandleError(session.ShowRecordings(ct))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/args"
 	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
 	"github.com/refi64/nsbox/internal/inventory"
 	"github.com/refi64/nsbox/internal/userdata"
 )
@@ -67,6 +68,14 @@ func (cmd *renameCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.Ex
 		return args.HandleError(err)
 	}
 
+	// The persistent unit is named after the container, so it would need to be recreated.
+	enabled, err := daemon.IsContainerEnabled(ct, usrdata)
+	if err != nil {
+		return args.HandleError(err)
+	} else if enabled {
+		return args.HandleError(errors.New("cannot rename an enabled container, disable it first"))
+	}
+
 	isDefault, err := isDefaultContainer(usrdata, cmd.current)
 	if err != nil {
 		return args.HandleError(errors.Wrap(err, "checking default container"))
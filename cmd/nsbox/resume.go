@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+)
+
+type resumeCommand struct {
+	container string
+}
+
+func newResumeCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &resumeCommand{})
+}
+
+func (*resumeCommand) Name() string {
+	return "resume"
+}
+
+func (*resumeCommand) Synopsis() string {
+	return "resume a paused container"
+}
+
+func (*resumeCommand) Usage() string {
+	return `resume <container>
+	Thaw a container that was previously paused.
+`
+}
+
+func (*resumeCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *resumeCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *resumeCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(ct.Resume(usrdata))
+}
@@ -6,11 +6,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/google/subcommands"
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/config"
 	"github.com/refi64/nsbox/internal/container"
 	"github.com/refi64/nsbox/internal/daemon"
 	"github.com/refi64/nsbox/internal/inventory"
@@ -22,6 +25,9 @@ type runCommand struct {
 	container string
 	restart   bool
 	noReplay  bool
+	detach    bool
+	name      string
+	record    string
 	command   []string
 }
 
@@ -41,12 +47,19 @@ func (*runCommand) Usage() string {
 	return `run [<container>] [<command...>]:
 	Run a command within container. If a command is not given, the shell will be run. If a
 	container is not given or is -, the default container will be run.
+
+	With -detach, the command is started in the background, where it keeps running even if
+	this terminal goes away. Use 'nsbox attach' to connect to it and 'nsbox sessions' to list
+	the running sessions.
 `
 }
 
 func (cmd *runCommand) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&cmd.restart, "restart", false, "Restart the container if it's already running")
 	fs.BoolVar(&cmd.noReplay, "no-replay", false, "Don't attempt to replay any updated Ansible playbooks")
+	fs.BoolVar(&cmd.detach, "detach", false, "Run the command in a detached session")
+	fs.StringVar(&cmd.name, "name", "", "The name of the detached session")
+	fs.StringVar(&cmd.record, "record", "", "Record the session to the given new asciicast file")
 }
 
 func (cmd *runCommand) ParsePositional(fs *flag.FlagSet) error {
@@ -55,6 +68,14 @@ func (cmd *runCommand) ParsePositional(fs *flag.FlagSet) error {
 		cmd.command = fs.Args()[1:]
 	}
 
+	if cmd.name != "" && !cmd.detach {
+		return errors.New("-name requires -detach")
+	}
+
+	if cmd.record != "" && cmd.detach {
+		return errors.New("detached sessions cannot be recorded")
+	}
+
 	return nil
 }
 
@@ -83,7 +104,26 @@ func (cmd *runCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitS
 
 	log.Debug("Container presumed to be ready, entering...")
 
-	exitCode, err := session.EnterContainer(ct, cmd.command, usrdata, cmd.noReplay, app.(*nsboxApp).workdir)
+	workdir := app.(*nsboxApp).workdir
+
+	if cmd.detach {
+		name, err := session.StartDetachedSession(ct, cmd.command, usrdata, cmd.noReplay, workdir,
+			cmd.name)
+		if err != nil {
+			return args.HandleError(err)
+		}
+
+		fmt.Printf("Started session %s, attach to it with: %s attach %s %s\n", name,
+			config.ProductName, ct.Name, name)
+		return subcommands.ExitSuccess
+	}
+
+	recordPath := cmd.record
+	if recordPath != "" && !filepath.IsAbs(recordPath) {
+		recordPath = filepath.Join(workdir, recordPath)
+	}
+
+	exitCode, err := session.EnterContainer(ct, cmd.command, usrdata, cmd.noReplay, workdir, recordPath)
 	if err != nil {
 		return args.HandleError(err)
 	}
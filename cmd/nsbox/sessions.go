@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/session"
+)
+
+type sessionsCommand struct {
+	container string
+}
+
+func newSessionsCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &sessionsCommand{})
+}
+
+func (*sessionsCommand) Name() string {
+	return "sessions"
+}
+
+func (*sessionsCommand) Synopsis() string {
+	return "list the detached sessions in a container"
+}
+
+func (*sessionsCommand) Usage() string {
+	return `sessions <container>
+	List the sessions started via 'nsbox run -detach' in the running container. Sessions that
+	exited while detached are kept until they're attached to, so their output can be seen.
+`
+}
+
+func (*sessionsCommand) SetFlags(fs *flag.FlagSet) {}
+
+func (cmd *sessionsCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *sessionsCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	ct, err := container.Open(app.(*nsboxApp).usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(session.ShowSessions(ct))
+}
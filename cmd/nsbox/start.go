@@ -0,0 +1,57 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/google/subcommands"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
+)
+
+type startCommand struct {
+	container string
+	restart   bool
+}
+
+func newStartCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &startCommand{})
+}
+
+func (*startCommand) Name() string {
+	return "start"
+}
+
+func (*startCommand) Synopsis() string {
+	return "start a container without entering it"
+}
+
+func (*startCommand) Usage() string {
+	return `start [-restart] <container>
+	Start the container in the background, without running a command inside. If the container
+	is already running, this does nothing.
+`
+}
+
+func (cmd *startCommand) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.restart, "restart", false, "Restart the container if it's already running")
+}
+
+func (cmd *startCommand) ParsePositional(fs *flag.FlagSet) error {
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func (cmd *startCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(daemon.RunContainerViaTransientUnit(ct, cmd.restart, usrdata))
+}
@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/machine1"
+	"github.com/google/subcommands"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/args"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/inventory"
+	"github.com/refi64/nsbox/internal/kill"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+type stopCommand struct {
+	container string
+	all       bool
+	timeout   time.Duration
+}
+
+func newStopCommand(app args.App) subcommands.Command {
+	return args.WrapSimpleCommand(app, &stopCommand{})
+}
+
+func (*stopCommand) Name() string {
+	return "stop"
+}
+
+func (*stopCommand) Synopsis() string {
+	return "gracefully stop a container"
+}
+
+func (*stopCommand) Usage() string {
+	return `stop [-timeout duration] <container>
+stop [-timeout duration] -all:
+	Stop the container, by powering it off if it's booted, or sending SIGTERM to all of its
+	processes otherwise. If it's still running after the timeout, it will be killed. If -all is
+	given, then every running container is stopped.
+`
+}
+
+func (cmd *stopCommand) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cmd.all, "all", false, "Stop all running containers")
+	fs.DurationVar(&cmd.timeout, "timeout", kill.DefaultTimeout, "How long to wait before killing the container")
+}
+
+func (cmd *stopCommand) ParsePositional(fs *flag.FlagSet) error {
+	if cmd.all {
+		return args.ExpectArgs(fs)
+	}
+
+	return args.ExpectArgs(fs, &cmd.container)
+}
+
+func runningContainers(usrdata *userdata.Userdata) ([]*container.Container, error) {
+	machined, err := machine1.New()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := inventory.List(usrdata)
+	if err != nil {
+		return nil, err
+	}
+
+	running := []*container.Container{}
+	for _, ct := range containers {
+		if _, err := machined.GetMachine(ct.MachineName(usrdata)); err == nil {
+			running = append(running, ct)
+		}
+	}
+
+	return running, nil
+}
+
+// Runs the action on every running container at once, failing if it failed for any of them.
+// verb describes the action in error messages, e.g. "stop".
+func forAllRunningContainers(usrdata *userdata.Userdata, verb string, action func(*container.Container) error) error {
+	containers, err := runningContainers(usrdata)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan string, len(containers))
+
+	for _, ct := range containers {
+		wg.Add(1)
+
+		go func(ct *container.Container) {
+			defer wg.Done()
+
+			if err := action(ct); err != nil {
+				log.Alertf("Failed to %s %s: %v", verb, ct.Name, err)
+				failures <- ct.Name
+			}
+		}(ct)
+	}
+
+	wg.Wait()
+	close(failures)
+
+	if len(failures) != 0 {
+		return errors.Errorf("failed to %s %d container(s)", verb, len(failures))
+	}
+
+	return nil
+}
+
+func (cmd *stopCommand) Execute(app args.App, fs *flag.FlagSet) subcommands.ExitStatus {
+	usrdata := app.(*nsboxApp).usrdata
+
+	if cmd.all {
+		return args.HandleError(forAllRunningContainers(usrdata, "stop", func(ct *container.Container) error {
+			return kill.StopContainer(usrdata, ct, cmd.timeout)
+		}))
+	}
+
+	ct, err := container.Open(usrdata, cmd.container)
+	if err != nil {
+		return args.HandleError(err)
+	}
+
+	return args.HandleError(kill.StopContainer(usrdata, ct, cmd.timeout))
+}
@@ -61,4 +61,6 @@ if [[ -z "$NSBOX_NO_REPLAY" ]]; then
   fi
 fi
 
+/run/host/nsbox/scripts/nsbox-run-hooks.sh on-enter
+
 exec runuser -s /bin/bash -- - "$NSBOX_USER" /run/host/nsbox/scripts/nsbox-enter-run.sh "$@"
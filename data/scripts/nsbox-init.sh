@@ -5,7 +5,27 @@
 # file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
 set -e
-trap 'echo "$BASH_SOURCE:$LINENO: $BASH_COMMAND" failed, sorry.; exit 1' ERR
+
+# Everything init prints is kept until it's done, so that if a step fails, the host can be told
+# which part of init it was in and what was printed along the way.
+init_log=/run/nsbox-init.log
+exec 3>&2 2>"$init_log"
+
+stage=environment
+
+on_error() {
+  local status=$? lineno=$1 command=$2
+
+  exec 2>&3 3>&-
+  cat "$init_log" >&2
+  echo "$BASH_SOURCE:$lineno: $command failed, sorry." >&2
+
+  NSBOX_INTERNAL=1 /run/host/nsbox/bin/nsbox-host notify-start-failed "$stage" \
+    "$command failed with status $status" "$(tail -n 50 "$init_log")" 2>/dev/null ||:
+  exit 1
+}
+
+trap 'on_error "$LINENO" "$BASH_COMMAND"' ERR
 
 . /run/host/nsbox/scripts/nsbox-apply-env.sh
 
@@ -13,6 +33,7 @@ user="$NSBOX_USER"
 uid="$NSBOX_UID"
 shell="$NSBOX_SHELL"
 
+stage=user
 rm -f /var/mail/"$user"
 
 if id "$user" &>/dev/null; then
@@ -21,6 +42,7 @@ else
   useradd -d "$NSBOX_HOME" -MU -u "$uid" -s "$shell" "$user" >/dev/null
 fi
 
+stage=sudo
 currently_can_sudo=$(id -Gnz "$user" | grep -Fqxz "$NSBOX_SUDO_GROUP" && echo 1 ||:)
 
 if [[ -n "$NSBOX_CAN_SUDO" && -z "$currently_can_sudo" ]]; then
@@ -29,11 +51,13 @@ elif [[ -z "$NSBOX_CAN_SUDO" && -n "$currently_can_sudo" ]]; then
   gpasswd -d "$user" "$NSBOX_SUDO_GROUP" >/dev/null
 fi
 
+stage=mail
 if [[ -d /run/host/nsbox/mail ]]; then
   rm -f /var/mail/"$user"
   ln -s /run/host/nsbox/mail /var/mail/"$user"
 fi
 
+stage=shadow
 update=1
 
 # XXX: shadow file hacks suck, but the only real workarond is to define a custom
@@ -56,12 +80,14 @@ if [[ -n "$update" ]]; then
   chmod 000 /etc/shadow
 fi
 
+stage=hostname
 if [[ "$NSBOX_BOOTED" == "1" ]]; then
   hostnamectl set-hostname "$HOSTNAME"
 else
   echo "$HOSTNAME" > /etc/hostname
 fi
 
+stage=setup
 ln -sf {/run/host,}/etc/locale.conf
 
 if [[ -n "$NSBOX_HOME_LINK_TARGET" ]]; then
@@ -75,4 +101,25 @@ fi
 
 mknod -m 666 /dev/fuse c 10 229 ||:
 
+# Non-booted containers have no desktop session of their own, so xdg-open should open things on
+# the host instead (/usr/local/bin comes first in PATH).
+xdg_open_link=/usr/local/bin/xdg-open
+if [[ -z "$NSBOX_BOOTED" ]]; then
+  # Don't clobber an xdg-open someone put there themselves.
+  if [[ ! -e "$xdg_open_link" && ! -L "$xdg_open_link" ]]; then
+    mkdir -p "$(dirname "$xdg_open_link")"
+    ln -s /run/host/nsbox/bin/nsbox-host "$xdg_open_link"
+  fi
+elif [[ "$(readlink "$xdg_open_link" ||:)" == /run/host/nsbox/bin/nsbox-host ]]; then
+  rm -f "$xdg_open_link"
+fi
+
+# Init itself is done, so there's nothing left that could fail it; hooks report their own
+# failures.
+trap - ERR
+exec 2>&3 3>&-
+cat "$init_log" >&2
+
+/run/host/nsbox/scripts/nsbox-run-hooks.sh on-start
+
 NSBOX_INTERNAL=1 exec /run/host/nsbox/bin/nsbox-host service "$NSBOX_CONTAINER"
@@ -0,0 +1,23 @@
+#!/bin/bash
+
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this
+# file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+# Runs every executable in the given in-container hook directory in order, reporting any
+# failures to the host. A failing hook never stops the rest from running.
+
+hook="$1"
+hook_dir=/etc/nsbox/hooks/"$hook"
+
+[[ -d "$hook_dir" ]] || exit 0
+
+for script in "$hook_dir"/*; do
+  [[ -f "$script" && -x "$script" ]] || continue
+
+  "$script" && status=0 || status=$?
+  if [[ $status -ne 0 ]]; then
+    echo "$hook hook $script failed with status $status." >&2
+    NSBOX_INTERNAL=1 /run/host/nsbox/bin/nsbox-host notify-hook-failed "$hook" "$script" $status ||:
+  fi
+done
@@ -1,6 +1,6 @@
 module github.com/refi64/nsbox
 
-go 1.12
+go 1.17
 
 require (
 	github.com/GehirnInc/crypt v0.0.0-20190301055215-6c0105aabd46
@@ -12,6 +12,7 @@ require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/godbus/dbus/v5 v5.0.3
 	github.com/google/go-containerregistry v0.0.0-20200320200342-35f57d7d4930
+	github.com/google/nftables v0.0.0-20220808154552-2eca00135732
 	github.com/google/subcommands v1.2.0
 	github.com/google/uuid v1.1.1
 	github.com/opencontainers/selinux v1.4.0
@@ -19,6 +20,21 @@ require (
 	github.com/refi64/go-lxtempdir v0.0.0-20190815193640-e8f0a4e7825f
 	github.com/varlink/go v0.3.0
 	github.com/vishvananda/netlink v1.1.0
-	golang.org/x/crypto v0.0.0-20200320181102-891825fb96df
-	golang.org/x/sys v0.0.0-20200321134203-328b4cd54aae
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/sys v0.0.0-20211205182925-97ca703d548d
+)
+
+require (
+	github.com/docker/cli v0.0.0-20191017083524-a8ff7f821017 // indirect
+	github.com/docker/docker v1.4.2-0.20190924003213-a8608b5b67c7 // indirect
+	github.com/docker/docker-credential-helpers v0.6.3 // indirect
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/josharian/native v0.0.0-20200817173448-b6b71def0850 // indirect
+	github.com/mattn/go-colorable v0.1.2 // indirect
+	github.com/mattn/go-isatty v0.0.8 // indirect
+	github.com/mdlayher/netlink v1.4.2 // indirect
+	github.com/mdlayher/socket v0.0.0-20211102153432-57e3fa563ecb // indirect
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df // indirect
+	golang.org/x/net v0.0.0-20211209124913-491a49abca63 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 )
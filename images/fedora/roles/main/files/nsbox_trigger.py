@@ -2,7 +2,7 @@
 # License, v. 2.0. If a copy of the MPL was not distributed with this
 # file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
-# Notifies the host to refresh the desktop files.
+# Notifies the host to refresh the exported desktop files and commands.
 
 from dnfpluginscore import logger
 import dnf
@@ -10,6 +10,7 @@ const ShareDir = "@SHARE_DIR"
 const StateDir = "@STATE_DIR"
 
 const ProductName = "@PRODUCT_NAME"
+const RdnsName = "@RDNS_NAME"
 
 const EnableSudo = "@ENABLE_SUDO" == "true"
 const EnableCvtsudoers = "@ENABLE_CVTSUDOERS" == "true"
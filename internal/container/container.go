@@ -12,11 +12,13 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	crypt "github.com/GehirnInc/crypt/sha512_crypt"
 	"github.com/coreos/go-systemd/v22/machine1"
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/network"
 	"github.com/refi64/nsbox/internal/paths"
 	"github.com/refi64/nsbox/internal/userdata"
 	"golang.org/x/sys/unix"
@@ -68,19 +70,234 @@ func (auth *Auth) UnmarshalJSON(data []byte) error {
 	return auth.Set(value)
 }
 
+type NetworkMode int
+
+const (
+	// Share the host's network namespace.
+	NetworkHost NetworkMode = iota
+	// A private network namespace with only a loopback device.
+	NetworkNone
+	// A veth link attached to an nsbox-managed zone bridge.
+	NetworkVeth
+	// A veth link attached to an existing bridge on the host.
+	NetworkBridge
+	// A macvlan device on top of a host interface.
+	NetworkMacvlan
+	// An ipvlan device on top of a host interface.
+	NetworkIpvlan
+)
+
+var (
+	networkModeToString = map[NetworkMode]string{
+		NetworkHost:    "host",
+		NetworkNone:    "none",
+		NetworkVeth:    "veth",
+		NetworkBridge:  "bridge",
+		NetworkMacvlan: "macvlan",
+		NetworkIpvlan:  "ipvlan",
+	}
+
+	stringToNetworkMode = map[string]NetworkMode{
+		"host":    NetworkHost,
+		"none":    NetworkNone,
+		"veth":    NetworkVeth,
+		"bridge":  NetworkBridge,
+		"macvlan": NetworkMacvlan,
+		"ipvlan":  NetworkIpvlan,
+	}
+)
+
+func (mode NetworkMode) String() string {
+	return networkModeToString[mode]
+}
+
+func (mode *NetworkMode) Set(value string) error {
+	newMode, ok := stringToNetworkMode[strings.ToLower(value)]
+	if !ok {
+		return errors.New("invalid network mode")
+	}
+
+	*mode = newMode
+	return nil
+}
+
+func (mode NetworkMode) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + mode.String() + `"`), nil
+}
+
+func (mode *NetworkMode) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return mode.Set(value)
+}
+
+// Returns true if the container gets its own network link that must be configured from
+// inside the container (i.e. via networkd).
+func (mode NetworkMode) HasLink() bool {
+	return mode != NetworkHost && mode != NetworkNone
+}
+
+// Returns true if the mode is attached to a host interface given by NetworkInterface.
+func (mode NetworkMode) NeedsInterface() bool {
+	return mode == NetworkBridge || mode == NetworkMacvlan || mode == NetworkIpvlan
+}
+
+type EgressPolicy int
+
+const (
+	EgressAllowAll EgressPolicy = iota
+	EgressDenyAll
+	EgressAllowList
+)
+
+var (
+	egressPolicyToString = map[EgressPolicy]string{
+		EgressAllowAll:  "allow-all",
+		EgressDenyAll:   "deny-all",
+		EgressAllowList: "allow-list",
+	}
+
+	stringToEgressPolicy = map[string]EgressPolicy{
+		"allow-all":  EgressAllowAll,
+		"deny-all":   EgressDenyAll,
+		"allow-list": EgressAllowList,
+	}
+)
+
+func (policy EgressPolicy) String() string {
+	return egressPolicyToString[policy]
+}
+
+func (policy *EgressPolicy) Set(value string) error {
+	newPolicy, ok := stringToEgressPolicy[strings.ToLower(value)]
+	if !ok {
+		return errors.New("invalid egress policy")
+	}
+
+	*policy = newPolicy
+	return nil
+}
+
+func (policy EgressPolicy) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + policy.String() + `"`), nil
+}
+
+func (policy *EgressPolicy) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return policy.Set(value)
+}
+
+type RestartPolicy int
+
+const (
+	RestartNo RestartPolicy = iota
+	RestartOnFailure
+)
+
+var (
+	restartPolicyToString = map[RestartPolicy]string{
+		RestartNo:        "no",
+		RestartOnFailure: "on-failure",
+	}
+
+	stringToRestartPolicy = map[string]RestartPolicy{
+		"no":         RestartNo,
+		"on-failure": RestartOnFailure,
+	}
+)
+
+func (policy RestartPolicy) String() string {
+	return restartPolicyToString[policy]
+}
+
+func (policy *RestartPolicy) Set(value string) error {
+	newPolicy, ok := stringToRestartPolicy[strings.ToLower(value)]
+	if !ok {
+		return errors.New("invalid restart policy")
+	}
+
+	*policy = newPolicy
+	return nil
+}
+
+func (policy RestartPolicy) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + policy.String() + `"`), nil
+}
+
+func (policy *RestartPolicy) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return policy.Set(value)
+}
+
+// A time.Duration that's stored in the config in its human-readable form, e.g. "10m".
+type Duration time.Duration
+
+func (duration Duration) String() string {
+	return time.Duration(duration).String()
+}
+
+func (duration *Duration) Set(value string) error {
+	newDuration, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.New("invalid duration")
+	}
+
+	*duration = Duration(newDuration)
+	return nil
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + duration.String() + `"`), nil
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return duration.Set(value)
+}
+
 type Config struct {
 	Image             string
 	Boot              bool
 	Auth              Auth
 	XdgDesktopExports []string
 	XdgDesktopExtra   []string
+	BinExports        []string
 	ExtraCapabilities []string
 	SyscallFilters    []string
 	ExtraBindMounts   []string
 	PrivateDirs       []string
 	ShareCgroupfs     bool
 	ShareDevices      []string
-	VirtualNetwork    bool
+	Network           NetworkMode
+	NetworkInterface  string `json:",omitempty"`
+	SharedNetwork     string `json:",omitempty"`
+	Egress            EgressPolicy
+	EgressAllow       []string
+	PreStartHook      string `json:",omitempty"`
+	PostStartHook     string `json:",omitempty"`
+	PostStopHook      string `json:",omitempty"`
+	Restart           RestartPolicy
+	IdleTimeout       Duration `json:",omitempty"`
+	RecordSessions    bool     `json:",omitempty"`
+	HostSpawn         bool     `json:",omitempty"`
+
+	// Legacy setting, superseded by Network.
+	VirtualNetwork bool `json:",omitempty"`
 }
 
 type Container struct {
@@ -106,7 +323,8 @@ func (l *Lock) Release() {
 const configJson = "config.json"
 const StageSuffix = ".stage"
 
-func validateName(name string) error {
+// Checks that the name can be used for a container.
+func ValidateName(name string) error {
 	if matched, _ := regexp.MatchString(`^[a-zA-Z0-9_-]+$`, name); !matched {
 		return errors.Errorf("invalid container name: %s", name)
 	}
@@ -132,7 +350,7 @@ func writeConfigToNewFile(config Config, path string) error {
 }
 
 func CreateStaged(usrdata *userdata.Userdata, name string, initialConfig Config) (*Container, error) {
-	if err := validateName(name); err != nil {
+	if err := ValidateName(name); err != nil {
 		return nil, err
 	}
 
@@ -188,6 +406,11 @@ func OpenPath(path, name string) (*Container, error) {
 		config.Image = "fedora:30"
 	}
 
+	if config.VirtualNetwork {
+		config.Network = NetworkVeth
+		config.VirtualNetwork = false
+	}
+
 	return &Container{
 		Name:   name,
 		Path:   path,
@@ -196,7 +419,7 @@ func OpenPath(path, name string) (*Container, error) {
 }
 
 func Open(usrdata *userdata.Userdata, name string) (*Container, error) {
-	if err := validateName(name); err != nil {
+	if err := ValidateName(name); err != nil {
 		return nil, err
 	}
 
@@ -219,7 +442,14 @@ func (container Container) MachineName(usrdata *userdata.Userdata) string {
 	return fmt.Sprintf("%s-%s", usrdata.EscapedUsername(), container.Name)
 }
 
-func (container Container) UpdateConfig() error {
+// The name of the systemd service that runs the container.
+func (container Container) ServiceName(usrdata *userdata.Userdata) string {
+	return fmt.Sprintf("nsbox-%s.service", container.MachineName(usrdata))
+}
+
+// Checks that the container's config is valid. UpdateConfig runs this before saving, but configs
+// can also be edited by hand, so anything about to use the config should check it as well.
+func (container Container) ValidateConfig() error {
 	if err := checkArrayItemsAgainstRegex(container.Config.ExtraBindMounts,
 		`^.+(:.+)?$`, "invalid bind mount"); err != nil {
 		return err
@@ -240,8 +470,52 @@ func (container Container) UpdateConfig() error {
 		return err
 	}
 
-	if container.Config.VirtualNetwork && !container.Config.Boot {
-		return errors.New("cannot use private networking on a non-booted container")
+	networkMode := container.Config.Network
+	if networkMode.HasLink() && !container.Config.Boot {
+		return errors.Errorf("cannot use %s networking on a non-booted container", networkMode)
+	}
+
+	if networkMode.NeedsInterface() {
+		if container.Config.NetworkInterface == "" {
+			return errors.Errorf("%s networking requires a network interface", networkMode)
+		}
+	} else if container.Config.NetworkInterface != "" {
+		return errors.Errorf("%s networking does not take a network interface", networkMode)
+	}
+
+	if container.Config.SharedNetwork != "" && networkMode != NetworkVeth {
+		return errors.New("shared networks require veth networking")
+	}
+
+	if container.Config.Egress != EgressAllowAll {
+		if networkMode != NetworkVeth {
+			return errors.New("egress policies require veth networking")
+		}
+
+		if container.Config.SharedNetwork != "" {
+			return errors.New("egress policies cannot be used with shared networks")
+		}
+	}
+
+	for _, allow := range container.Config.EgressAllow {
+		if _, err := network.ParseEgressTarget(allow); err != nil {
+			return err
+		}
+	}
+
+	if len(container.Config.NetworkInterface) >= unix.IFNAMSIZ {
+		return errors.New("network interface name is too long")
+	}
+
+	for _, hook := range []string{container.Config.PreStartHook, container.Config.PostStartHook,
+		container.Config.PostStopHook} {
+		if hook != "" && !path.IsAbs(hook) {
+			return errors.New("hooks must be absolute paths")
+		}
+	}
+
+	if container.Config.IdleTimeout < 0 {
+		return errors.New("idle timeout must not be negative")
 	}
 
 	for _, dev := range container.Config.ShareDevices {
@@ -254,6 +528,14 @@ func (container Container) UpdateConfig() error {
 		}
 	}
 
+	return nil
+}
+
+func (container Container) UpdateConfig() error {
+	if err := container.ValidateConfig(); err != nil {
+		return err
+	}
+
 	configPath := filepath.Join(container.Path, configJson)
 	tempConfigPath := configPath + ".tmp"
 
@@ -293,6 +575,9 @@ func (container Container) Leader(usrdata *userdata.Userdata) (uint32, error) {
 	return props["Leader"].(uint32), nil
 }
 
+// Returned by Lock if NoWaitForLock was given and the lock is already held.
+var ErrLocked = errors.New("container is locked")
+
 type LockWaitRequest int
 
 const (
@@ -351,8 +636,10 @@ func (container Container) Lock(level LockLevel, wait LockWaitRequest) (*Lock, e
 	}
 
 	if err := unix.Flock(fd, operation); err != nil {
+		unix.Close(fd)
+
 		if errno, ok := err.(unix.Errno); ok && errno == unix.EWOULDBLOCK {
-			return nil, errors.New("container is locked")
+			return nil, ErrLocked
 		}
 
 		return nil, errors.Wrap(err, "failed to lock container directory")
@@ -424,6 +711,48 @@ func (container Container) PrivateHomeStorageChild(usrdata *userdata.Userdata,
 	return filepath.Join(parts...)
 }
 
+// Returns where the given path inside the container is shared from on the host, going through
+// the home and private directory binds, or "" if it isn't shared.
+func (container Container) HostPath(usrdata *userdata.Userdata, path string) string {
+	path = filepath.Clean(path)
+
+	homes := []string{usrdata.User.HomeDir}
+	// On Silverblue, the resolved home is bound too (see bindHome).
+	if resolved, err := filepath.EvalSymlinks(usrdata.User.HomeDir); err == nil {
+		homes = append(homes, resolved)
+	}
+
+	for _, home := range homes {
+		rel, err := filepath.Rel(home, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		// Private dirs are bound over home, so the most specific one wins.
+		private := ""
+		for _, dir := range container.Config.PrivateDirs {
+			dir = filepath.Clean(dir)
+			if (dir == "." || rel == dir || strings.HasPrefix(rel, dir+"/")) &&
+				(private == "" || private == "." || len(dir) > len(private)) {
+				private = dir
+			}
+		}
+
+		if private == "" {
+			return filepath.Join(home, rel)
+		}
+
+		sub, err := filepath.Rel(private, rel)
+		if err != nil {
+			return ""
+		}
+
+		return container.PrivateHomeStorageChild(usrdata, "home", private, sub)
+	}
+
+	return ""
+}
+
 func (container Container) Staged() bool {
 	return strings.HasSuffix(container.Path, StageSuffix)
 }
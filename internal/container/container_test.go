@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"os/user"
+	"testing"
+
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+func TestHostPath(t *testing.T) {
+	// The home doesn't exist, so it won't be resolved to anything else.
+	usrdata := &userdata.Userdata{User: &user.User{HomeDir: "/nonexistent/home/user"}}
+	private := "/nonexistent/home/user/.var/nsbox/test/home"
+
+	for _, test := range []struct {
+		privateDirs []string
+		path        string
+		expected    string
+	}{
+		// Home and its children are bound as-is.
+		{path: "/nonexistent/home/user", expected: "/nonexistent/home/user"},
+		{path: "/nonexistent/home/user/src/project", expected: "/nonexistent/home/user/src/project"},
+		{path: "/nonexistent/home/user/src/../docs/", expected: "/nonexistent/home/user/docs"},
+
+		// Anything outside of home isn't shared.
+		{path: "/", expected: ""},
+		{path: "/usr/bin", expected: ""},
+		{path: "/nonexistent/home/user2", expected: ""},
+		{path: "/nonexistent/home/user/..", expected: ""},
+		{path: "relative/path", expected: ""},
+
+		// Private directories are bound from the container's private home.
+		{
+			privateDirs: []string{"src"},
+			path:        "/nonexistent/home/user/src/project",
+			expected:    private + "/src/project",
+		},
+		{
+			privateDirs: []string{"src"},
+			path:        "/nonexistent/home/user/src",
+			expected:    private + "/src",
+		},
+		{
+			privateDirs: []string{"src"},
+			path:        "/nonexistent/home/user/srcs",
+			expected:    "/nonexistent/home/user/srcs",
+		},
+		{
+			privateDirs: []string{"."},
+			path:        "/nonexistent/home/user/docs",
+			expected:    private + "/docs",
+		},
+		{
+			privateDirs: []string{"."},
+			path:        "/nonexistent/home/user",
+			expected:    private,
+		},
+
+		// The most specific private directory wins.
+		{
+			privateDirs: []string{".", "src", "src/project/"},
+			path:        "/nonexistent/home/user/src/project/main.go",
+			expected:    private + "/src/project/main.go",
+		},
+		{
+			privateDirs: []string{"src/project", "src", "."},
+			path:        "/nonexistent/home/user/src/other",
+			expected:    private + "/src/other",
+		},
+	} {
+		ct := Container{Name: "test", Config: &Config{PrivateDirs: test.privateDirs}}
+		if result := ct.HostPath(usrdata, test.path); result != test.expected {
+			t.Errorf("%q with private dirs %v: expected %q, got %q", test.path, test.privateDirs,
+				test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+
+	systemdService       = "org.freedesktop.systemd1"
+	systemdManagerObject = "/org/freedesktop/systemd1"
+
+	systemdFreezeUnitMethod = "org.freedesktop.systemd1.Manager.FreezeUnit"
+	systemdThawUnitMethod   = "org.freedesktop.systemd1.Manager.ThawUnit"
+
+	dbusUnknownMethodError = "org.freedesktop.DBus.Error.UnknownMethod"
+)
+
+// Returns the cgroup of the container's unit, relative to the cgroup root, or an empty string if
+// the container isn't running.
+func (container Container) unitControlGroup(systemd *dbus.Conn, usrdata *userdata.Userdata) (string, error) {
+	prop, err := systemd.GetServiceProperty(container.ServiceName(usrdata), "ControlGroup")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get unit cgroup")
+	}
+
+	cgroup, _ := prop.Value.Value().(string)
+	return cgroup, nil
+}
+
+// Returns the path to the cgroup.freeze file for the container's unit, or an empty string if the
+// container isn't running.
+func (container Container) cgroupFreezePath(systemd *dbus.Conn, usrdata *userdata.Userdata) (string, error) {
+	cgroup, err := container.unitControlGroup(systemd, usrdata)
+	if err != nil || cgroup == "" {
+		return "", err
+	}
+
+	return filepath.Join(cgroupRoot, cgroup, "cgroup.freeze"), nil
+}
+
+func (container Container) IsPaused(usrdata *userdata.Userdata) (bool, error) {
+	systemd, err := dbus.New()
+	if err != nil {
+		return false, err
+	}
+
+	defer systemd.Close()
+
+	freezePath, err := container.cgroupFreezePath(systemd, usrdata)
+	if err != nil || freezePath == "" {
+		return false, err
+	}
+
+	contents, err := ioutil.ReadFile(freezePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No cgroup v2 freezer, so it can't be paused.
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "failed to read freezer state")
+	}
+
+	return strings.TrimSpace(string(contents)) == "1", nil
+}
+
+// Freezes or thaws the container's unit. This goes through systemd if possible, so it knows
+// about the unit's state, and otherwise writes to the cgroup freezer directly.
+func (container Container) setFrozen(usrdata *userdata.Userdata, frozen bool) error {
+	systemd, err := dbus.New()
+	if err != nil {
+		return err
+	}
+
+	defer systemd.Close()
+
+	freezePath, err := container.cgroupFreezePath(systemd, usrdata)
+	if err != nil {
+		return err
+	} else if freezePath == "" {
+		return errors.Errorf("container %s is not running", container.Name)
+	}
+
+	systemBus, err := godbus.SystemBus()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to system bus")
+	}
+
+	method := systemdThawUnitMethod
+	if frozen {
+		method = systemdFreezeUnitMethod
+	}
+
+	object := systemBus.Object(systemdService, systemdManagerObject)
+	err = object.Call(method, 0, container.ServiceName(usrdata)).Err
+	if err == nil {
+		return nil
+	}
+
+	if dbusErr, ok := err.(godbus.Error); !ok || dbusErr.Name != dbusUnknownMethodError {
+		return errors.Wrap(err, "failed to ask systemd to change freezer state")
+	}
+
+	log.Debug("systemd does not support freezing units, falling back to cgroup.freeze")
+
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+
+	if err := ioutil.WriteFile(freezePath, []byte(value), 0); err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("pausing containers requires cgroup v2")
+		}
+
+		return errors.Wrap(err, "failed to write freezer state")
+	}
+
+	return nil
+}
+
+func (container Container) Pause(usrdata *userdata.Userdata) error {
+	return container.setFrozen(usrdata, true)
+}
+
+func (container Container) Resume(usrdata *userdata.Userdata) error {
+	return container.setFrozen(usrdata, false)
+}
@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	hookFailuresJson = "hook-failures.json"
+	// Only the most recent failures are kept, so a hook failing on every enter doesn't grow
+	// the file forever.
+	maxHookFailures = 10
+)
+
+// An in-container hook that failed since the container was last started.
+type HookFailure struct {
+	// The hook directory, e.g. "on-start".
+	Hook   string
+	Path   string
+	Status int64
+	Time   time.Time
+}
+
+func (container Container) hookFailuresPath() string {
+	return filepath.Join(container.Path, hookFailuresJson)
+}
+
+// Returns the hook failures since the container was last started, oldest first.
+func (container Container) LoadHookFailures() ([]HookFailure, error) {
+	data, err := ioutil.ReadFile(container.hookFailuresPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read hook failures")
+	}
+
+	var failures []HookFailure
+	if err := json.Unmarshal(data, &failures); err != nil {
+		return nil, errors.Wrap(err, "failed to parse hook failures")
+	}
+
+	return failures, nil
+}
+
+func (container Container) AddHookFailure(failure HookFailure) error {
+	failures, err := container.LoadHookFailures()
+	if err != nil {
+		return err
+	}
+
+	failures = append(failures, failure)
+	if len(failures) > maxHookFailures {
+		failures = failures[len(failures)-maxHookFailures:]
+	}
+
+	data, err := json.Marshal(failures)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal hook failures")
+	}
+
+	tempPath := container.hookFailuresPath() + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to save hook failures")
+	}
+
+	if err := os.Rename(tempPath, container.hookFailuresPath()); err != nil {
+		return errors.Wrap(err, "failed to save hook failures")
+	}
+
+	return nil
+}
+
+func (container Container) ClearHookFailures() error {
+	if err := os.Remove(container.hookFailuresPath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to clear hook failures")
+	}
+
+	return nil
+}
@@ -39,11 +39,18 @@ func (ct Container) ShowInfo(usrdata *userdata.Userdata) error {
 
 	machineName := ct.MachineName(usrdata)
 
-	unitMemory, err := systemd.GetServiceProperty(fmt.Sprintf("nsbox-%s.service", machineName), "MemoryCurrent")
+	serviceName := ct.ServiceName(usrdata)
+
+	unitMemory, err := systemd.GetServiceProperty(serviceName, "MemoryCurrent")
 	if err != nil {
 		log.Debug("failed to get unit MemoryCurrent:", err)
 	}
 
+	unitFileState, err := systemd.GetUnitProperty(serviceName, "UnitFileState")
+	if err != nil {
+		log.Debug("failed to get unit UnitFileState:", err)
+	}
+
 	machineProps, err := machined.DescribeMachine(machineName)
 	if err != nil {
 		log.Debug("failed to describe machine:", err)
@@ -55,18 +62,83 @@ func (ct Container) ShowInfo(usrdata *userdata.Userdata) error {
 	fmt.Fprintln(writer, "Name:\t", ct.Name)
 	fmt.Fprintln(writer, "Booted:\t", boolYesNo(ct.Config.Boot))
 
+	enabled := unitFileState != nil && unitFileState.Value.Value() == "enabled"
+	fmt.Fprintln(writer, "Enabled:\t", boolYesNo(enabled))
+	fmt.Fprintln(writer, "Restart:\t", ct.Config.Restart)
+	if ct.Config.IdleTimeout != 0 {
+		fmt.Fprintln(writer, "Idle timeout:\t", ct.Config.IdleTimeout)
+	}
+	fmt.Fprintln(writer, "Records sessions:\t", boolYesNo(ct.Config.RecordSessions))
+	fmt.Fprintln(writer, "Host spawn:\t", boolYesNo(ct.Config.HostSpawn))
 	fmt.Fprintln(writer, "Shares cgroups:\t", boolYesNo(ct.Config.ShareCgroupfs))
-	fmt.Fprintln(writer, "Virtual network:\t", boolYesNo(ct.Config.VirtualNetwork))
+	if ct.Config.Network.NeedsInterface() {
+		fmt.Fprintf(writer, "Network:\t %s (%s)\n", ct.Config.Network, ct.Config.NetworkInterface)
+	} else {
+		fmt.Fprintln(writer, "Network:\t", ct.Config.Network)
+	}
+
+	if ct.Config.SharedNetwork != "" {
+		fmt.Fprintln(writer, "Shared network:\t", ct.Config.SharedNetwork)
+	}
+
+	if ct.Config.Egress == EgressAllowList {
+		fmt.Fprintf(writer, "Egress:\t %s (%s)\n", ct.Config.Egress, strings.Join(ct.Config.EgressAllow, ", "))
+	} else {
+		fmt.Fprintln(writer, "Egress:\t", ct.Config.Egress)
+	}
 
 	fmt.Fprintln(writer, "Shared devices:\t", strings.Join(ct.Config.ShareDevices, ", "))
 
+	for _, hook := range []struct{ name, path string }{
+		{"Pre-start hook", ct.Config.PreStartHook},
+		{"Post-start hook", ct.Config.PostStartHook},
+		{"Post-stop hook", ct.Config.PostStopHook},
+	} {
+		if hook.path != "" {
+			fmt.Fprintf(writer, "%s:\t %s\n", hook.name, hook.path)
+		}
+	}
+
 	fmt.Fprintln(writer, "XDG desktop exports:\t", strings.Join(ct.Config.XdgDesktopExports, ", "))
 	fmt.Fprintln(writer, "XDG desktop extra:\t", strings.Join(ct.Config.XdgDesktopExtra, ", "))
+	fmt.Fprintln(writer, "Bin exports:\t", strings.Join(ct.Config.BinExports, ", "))
 
 	if machineProps != nil {
 		usec := machineProps["Timestamp"].(uint64)
 		tm := time.Unix(int64(usec)/int64(time.Second/time.Microsecond), 0)
 		fmt.Fprintf(writer, "Running:\t since %s (%s)\n", tm.Format(time.RFC1123), humanize.Time(tm))
+
+		paused, err := ct.IsPaused(usrdata)
+		if err != nil {
+			log.Debug("failed to get paused state:", err)
+		}
+
+		fmt.Fprintln(writer, "Paused:\t", boolYesNo(paused))
+
+		// The host service is frozen alongside the container, so don't wait on it if paused.
+		if !paused {
+			if sessions, idle, err := ct.Sessions(); err != nil {
+				log.Debug("failed to get sessions:", err)
+				fmt.Fprintln(writer, "Sessions:\t unknown")
+			} else {
+				fmt.Fprintln(writer, "Sessions:\t", sessions)
+				if sessions == 0 {
+					fmt.Fprintf(writer, "Idle:\t for %s\n", idle)
+				} else {
+					fmt.Fprintln(writer, "Idle:\t no")
+				}
+			}
+		}
+
+		failures, err := ct.LoadHookFailures()
+		if err != nil {
+			log.Debug("failed to load hook failures:", err)
+		}
+
+		for _, failure := range failures {
+			fmt.Fprintf(writer, "Failed hook:\t %s %s (status %d, %s)\n", failure.Hook, failure.Path,
+				failure.Status, humanize.Time(failure.Time))
+		}
 	} else {
 		fmt.Fprintln(writer, "Running:\t no")
 	}
@@ -0,0 +1,442 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+	"golang.org/x/sys/unix"
+)
+
+// Set in the environment of commands started via 'nsbox run', to the PID of the host process
+// that started them or the name of the detached session. This is used to find sessions among
+// the container's processes.
+const SessionEnvVar = "NSBOX_SESSION"
+
+// The units of a process's start time in /proc/PID/stat. The kernel always exposes these in
+// USER_HZ, which is 100 on every architecture.
+const clockTicksPerSecond = 100
+
+type Process struct {
+	Pid          int
+	ContainerPid int
+	Uid          int
+	Started      time.Time
+	Tty          string
+	Command      []string
+
+	// Set for the first process of each session started via 'nsbox run'.
+	IsSession bool
+	Cwd       string
+
+	ppid    int
+	session string
+}
+
+// Returns the container's cgroup, relative to the cgroup root. If the unit doesn't have one
+// (e.g. it's not managed by nsbox), then the cgroup of machined's leader process is used.
+func (container Container) controlGroup(usrdata *userdata.Userdata) (string, error) {
+	systemd, err := dbus.New()
+	if err != nil {
+		return "", err
+	}
+
+	defer systemd.Close()
+
+	if cgroup, err := container.unitControlGroup(systemd, usrdata); err != nil {
+		log.Debug("failed to get unit cgroup:", err)
+	} else if cgroup != "" {
+		return cgroup, nil
+	}
+
+	leader, err := container.Leader(usrdata)
+	if err != nil {
+		return "", errors.Errorf("container %s is not running", container.Name)
+	}
+
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", leader))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read leader cgroup")
+	}
+
+	// Prefer the unified hierarchy's entry, but fall back to systemd's own one on cgroup v1.
+	var cgroup string
+	for _, line := range strings.Split(string(contents), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		} else if parts[1] == "name=systemd" {
+			cgroup = parts[2]
+		}
+	}
+
+	if cgroup == "" {
+		return "", errors.New("failed to find leader cgroup")
+	}
+
+	return cgroup, nil
+}
+
+// Returns every PID inside the cgroup and its children.
+func cgroupPids(cgroup string) ([]int, error) {
+	var root string
+	for _, hierarchy := range []string{"", "unified", "systemd"} {
+		path := filepath.Join(cgroupRoot, hierarchy, cgroup)
+		if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err == nil {
+			root = path
+			break
+		}
+	}
+
+	if root == "" {
+		return nil, errors.Errorf("failed to find cgroup %s", cgroup)
+	}
+
+	pids := []int{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// cgroups can disappear while being walked.
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.IsDir() || info.Name() != "cgroup.procs" {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		for _, field := range strings.Fields(string(contents)) {
+			pid, err := strconv.Atoi(field)
+			if err != nil {
+				return errors.Wrapf(err, "invalid pid in %s", path)
+			}
+
+			pids = append(pids, pid)
+		}
+
+		return nil
+	})
+
+	return pids, err
+}
+
+// Returns an identifier for the PID namespace of the given process, which is equal for every
+// process in the same namespace.
+func pidNamespace(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+}
+
+func readBootTime() (time.Time, error) {
+	contents, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to read /proc/stat")
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			btime, err := strconv.ParseInt(strings.TrimPrefix(line, "btime "), 10, 64)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "invalid boot time")
+			}
+
+			return time.Unix(btime, 0), nil
+		}
+	}
+
+	return time.Time{}, errors.New("failed to find boot time")
+}
+
+func formatTty(dev uint64) string {
+	major := unix.Major(dev)
+	minor := unix.Minor(dev)
+
+	switch {
+	case dev == 0:
+		return "-"
+	case major >= 136 && major <= 143:
+		return fmt.Sprintf("pts/%d", (major-136)*256+minor)
+	case major == 4 && minor < 64:
+		return fmt.Sprintf("tty%d", minor)
+	default:
+		return fmt.Sprintf("%d:%d", major, minor)
+	}
+}
+
+func (container Container) readProcess(pid int, bootTime time.Time) (*Process, error) {
+	procDir := fmt.Sprintf("/proc/%d", pid)
+	process := &Process{Pid: pid, Tty: "-"}
+
+	stat, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name can contain spaces and parentheses, so skip past the last one.
+	commEnd := bytes.LastIndexByte(stat, ')')
+	commStart := bytes.IndexByte(stat, '(')
+	if commStart == -1 || commEnd == -1 {
+		return nil, errors.Errorf("invalid stat for %d", pid)
+	}
+
+	comm := string(stat[commStart+1 : commEnd])
+
+	// Index 0 is the state, which is the third field.
+	fields := strings.Fields(string(stat[commEnd+1:]))
+	if len(fields) < 20 {
+		return nil, errors.Errorf("truncated stat for %d", pid)
+	}
+
+	process.ppid, _ = strconv.Atoi(fields[1])
+
+	if ttyNr, err := strconv.ParseUint(fields[4], 10, 64); err == nil {
+		process.Tty = formatTty(ttyNr)
+	}
+
+	if startTicks, err := strconv.ParseInt(fields[19], 10, 64); err == nil {
+		process.Started = bootTime.Add(time.Duration(startTicks) * time.Second / clockTicksPerSecond)
+	}
+
+	status, err := os.Open(filepath.Join(procDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer status.Close()
+
+	process.ContainerPid = -1
+	process.Uid = -1
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		values := strings.Fields(parts[1])
+		if len(values) == 0 {
+			continue
+		}
+
+		switch parts[0] {
+		case "Uid":
+			process.Uid, _ = strconv.Atoi(values[0])
+		case "NSpid":
+			// The last PID is the one in the innermost namespace, i.e. the container's.
+			process.ContainerPid, _ = strconv.Atoi(values[len(values)-1])
+		}
+	}
+
+	if cmdline, err := ioutil.ReadFile(filepath.Join(procDir, "cmdline")); err == nil {
+		cmdline = bytes.TrimRight(cmdline, "\x00")
+		if len(cmdline) != 0 {
+			process.Command = strings.Split(string(cmdline), "\x00")
+		}
+	}
+
+	if len(process.Command) == 0 {
+		process.Command = []string{"[" + comm + "]"}
+	}
+
+	if environ, err := ioutil.ReadFile(filepath.Join(procDir, "environ")); err == nil {
+		prefix := SessionEnvVar + "="
+		for _, env := range strings.Split(string(environ), "\x00") {
+			if strings.HasPrefix(env, prefix) {
+				process.session = strings.TrimPrefix(env, prefix)
+				break
+			}
+		}
+	} else {
+		log.Debugf("failed to read environment of %d: %v", pid, err)
+	}
+
+	if process.session != "" {
+		if cwd, err := os.Readlink(filepath.Join(procDir, "cwd")); err == nil {
+			// Depending on the mount namespace, the path may be shown relative to the host.
+			if strings.HasPrefix(cwd, container.Storage()+"/") {
+				cwd = strings.TrimPrefix(cwd, container.Storage())
+			}
+
+			process.Cwd = cwd
+		}
+	}
+
+	return process, nil
+}
+
+// Returns the processes running inside the container, sorted by PID.
+func (container Container) Processes(usrdata *userdata.Userdata) ([]*Process, error) {
+	cgroup, err := container.controlGroup(usrdata)
+	if err != nil {
+		return nil, err
+	}
+
+	pids, err := cgroupPids(cgroup)
+	if err != nil {
+		return nil, err
+	}
+
+	// The unit's cgroup also contains nspawn itself and anything else run on the host side, so
+	// only keep the processes that share the PID namespace of the container's init.
+	leader, err := container.Leader(usrdata)
+	if err != nil {
+		return nil, errors.Errorf("container %s is not running", container.Name)
+	}
+
+	namespace, err := pidNamespace(int(leader))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the container's pid namespace")
+	}
+
+	bootTime, err := readBootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := []*Process{}
+	byPid := map[int]*Process{}
+
+	for _, pid := range pids {
+		if processNamespace, err := pidNamespace(pid); err != nil {
+			log.Debugf("failed to get pid namespace of %d: %v", pid, err)
+			continue
+		} else if processNamespace != namespace {
+			continue
+		}
+
+		process, err := container.readProcess(pid, bootTime)
+		if err != nil {
+			// The process most likely exited in the meantime.
+			log.Debugf("failed to read process %d: %v", pid, err)
+			continue
+		}
+
+		processes = append(processes, process)
+		byPid[pid] = process
+	}
+
+	// A session starts at the first process that has the session variable, which its children
+	// then inherit.
+	for _, process := range processes {
+		if process.session == "" {
+			continue
+		}
+
+		parent, ok := byPid[process.ppid]
+		process.IsSession = !ok || parent.session != process.session
+	}
+
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].Pid < processes[j].Pid
+	})
+
+	return processes, nil
+}
+
+// Maps the UIDs in the container's passwd file to user names.
+func (container Container) readUserNames() map[int]string {
+	names := map[int]string{}
+
+	passwd, err := os.Open(container.StorageChild("etc", "passwd"))
+	if err != nil {
+		log.Debug("failed to open container passwd:", err)
+		return names
+	}
+
+	defer passwd.Close()
+
+	scanner := bufio.NewScanner(passwd)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), ":")
+		if len(parts) < 3 {
+			continue
+		}
+
+		if uid, err := strconv.Atoi(parts[2]); err == nil {
+			if _, exists := names[uid]; !exists {
+				names[uid] = parts[0]
+			}
+		}
+	}
+
+	return names
+}
+
+func (container Container) ShowProcesses(usrdata *userdata.Userdata) error {
+	processes, err := container.Processes(usrdata)
+	if err != nil {
+		return err
+	}
+
+	userNames := container.readUserNames()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "PID\tCT PID\tUSER\tSTARTED\tTTY\tSESSION\tCOMMAND")
+
+	now := time.Now()
+	for _, process := range processes {
+		containerPid := "-"
+		if process.ContainerPid != -1 {
+			containerPid = fmt.Sprint(process.ContainerPid)
+		}
+
+		user, ok := userNames[process.Uid]
+		if !ok {
+			user = fmt.Sprint(process.Uid)
+		}
+
+		// Like ps, only show the time for processes started today.
+		var started string
+		if process.Started.YearDay() == now.YearDay() && process.Started.Year() == now.Year() {
+			started = process.Started.Format("15:04")
+		} else {
+			started = process.Started.Format("Jan02")
+		}
+
+		session := "-"
+		if process.IsSession {
+			session = process.Cwd
+			if session == "" {
+				session = "?"
+			}
+		}
+
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", process.Pid, containerPid, user,
+			started, process.Tty, session, strings.Join(process.Command, " "))
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/paths"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/varlink/go/varlink"
+)
+
+func (container Container) connectHostService() (*varlink.Connection, error) {
+	socketPath := container.StorageChild(paths.InContainerPrivPath, paths.HostServiceSocketName)
+
+	conn, err := varlink.NewConnection(context.Background(), "unix://"+socketPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to host service")
+	}
+
+	return conn, nil
+}
+
+// Tells the running container's host service that this process has entered a session, so the
+// container isn't considered idle until NotifySessionExit is called or this process dies.
+func (container Container) NotifySessionEnter() error {
+	conn, err := container.connectHostService()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	return devnsbox.NotifySessionEnter().Call(context.Background(), conn, int64(os.Getpid()))
+}
+
+func (container Container) NotifySessionExit() error {
+	conn, err := container.connectHostService()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	return devnsbox.NotifySessionExit().Call(context.Background(), conn, int64(os.Getpid()))
+}
+
+// Returns the number of sessions in the running container, and how long it's been idle for if
+// there are none.
+func (container Container) Sessions() (int, time.Duration, error) {
+	conn, err := container.connectHostService()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer conn.Close()
+
+	count, idleSeconds, err := devnsbox.GetSessions().Call(context.Background(), conn)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get sessions")
+	}
+
+	return int(count), time.Duration(idleSeconds) * time.Second, nil
+}
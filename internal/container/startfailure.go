@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package container
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const startFailureJson = "start-failure.json"
+
+// Why the container's init failed during its last start, as reported by the init script.
+type StartFailure struct {
+	// The part of the init script that failed, e.g. "user".
+	Stage   string
+	Message string
+	// The output of the init script up until the failure.
+	Log string
+}
+
+func (container Container) startFailurePath() string {
+	return filepath.Join(container.Path, startFailureJson)
+}
+
+// Saves the failure, so whoever is starting the container can show it.
+func (container Container) SaveStartFailure(failure *StartFailure) error {
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal start failure")
+	}
+
+	if err := ioutil.WriteFile(container.startFailurePath(), data, 0644); err != nil {
+		return errors.Wrap(err, "failed to save start failure")
+	}
+
+	return nil
+}
+
+// Returns the failure saved by the last start, or nil if there wasn't one.
+func (container Container) LoadStartFailure() (*StartFailure, error) {
+	data, err := ioutil.ReadFile(container.startFailurePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read start failure")
+	}
+
+	var failure StartFailure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		return nil, errors.Wrap(err, "failed to parse start failure")
+	}
+
+	return &failure, nil
+}
+
+func (container Container) ClearStartFailure() error {
+	if err := os.Remove(container.startFailurePath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to clear start failure")
+	}
+
+	return nil
+}
@@ -7,13 +7,14 @@
 package daemon
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	sdutil "github.com/coreos/go-systemd/v22/util"
@@ -26,8 +27,11 @@ import (
 	"github.com/refi64/nsbox/internal/paths"
 	"github.com/refi64/nsbox/internal/selinux"
 	"github.com/refi64/nsbox/internal/userdata"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
 	"github.com/refi64/nsbox/internal/varlinkhost"
+	"github.com/refi64/nsbox/internal/varlinkpeer"
 	"github.com/varlink/go/varlink"
+	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
 
@@ -200,11 +204,11 @@ func writeContainerFiles(ct *container.Container, hostPrivPath string, usrdata *
 	return nil
 }
 
-func startVarlinkService(ct *container.Container, hostPrivPath string) (*net.Listener, error) {
+func startVarlinkService(ct *container.Container, usrdata *userdata.Userdata, hostPrivPath string, onStart, onIdle func()) (*net.UnixListener, error) {
 	service, err := varlink.NewService(
 		"nsbox",
 		"nsbox",
-		"1",
+		strconv.Itoa(devnsbox.ProtocolVersion),
 		"https://nsbox.dev/",
 	)
 
@@ -212,28 +216,30 @@ func startVarlinkService(ct *container.Container, hostPrivPath string) (*net.Lis
 		return nil, errors.Wrap(err, "failed to create new varlink service")
 	}
 
-	host := varlinkhost.New(ct)
+	host := varlinkhost.New(ct, usrdata, onStart, onIdle)
 	if err := service.RegisterInterface(host); err != nil {
 		return nil, errors.Wrap(err, "failed to register varlink interface")
 	}
 
-	serviceUri := "unix://" + filepath.Join(hostPrivPath, paths.HostServiceSocketName)
-	if err := service.Bind(context.Background(), serviceUri); err != nil {
-		return nil, errors.Wrap(err, "failed to bind to varlink service")
+	socketPath := filepath.Join(hostPrivPath, paths.HostServiceSocketName)
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to remove old host service socket")
 	}
 
-	listener, err := service.GetListener()
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to prepare to listen on varlink service")
+		return nil, errors.Wrap(err, "failed to bind to varlink service")
 	}
 
-	go func() {
-		if err := service.DoListen(context.Background(), 0); err != nil {
-			log.Alert("failed to listen on host service socket:", err)
-		}
-	}()
+	// The container's user needs to connect to spawn host commands, so the methods check who
+	// is calling instead.
+	if err := os.Chmod(socketPath, 0666); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to make host service socket accessible")
+	}
 
-	return &listener, err
+	varlinkpeer.Serve(service, listener)
+	return listener, nil
 }
 
 func MkdirAllOwnedByUser(path string, uid int, gid int, perm os.FileMode) error {
@@ -284,6 +290,26 @@ func bindPrivate(builder *nspawn.Builder, ct *container.Container, usrdata *user
 	return nil
 }
 
+func egressPolicy(ct *container.Container) (network.EgressPolicy, error) {
+	switch ct.Config.Egress {
+	case container.EgressDenyAll:
+		return network.NewRestrictedEgressPolicy(nil)
+	case container.EgressAllowList:
+		return network.NewRestrictedEgressPolicy(ct.Config.EgressAllow)
+	}
+
+	return network.AllowAllEgress, nil
+}
+
+var nspawnNetworkModes = map[container.NetworkMode]nspawn.NetworkMode{
+	container.NetworkHost:    nspawn.NetworkHost,
+	container.NetworkNone:    nspawn.NetworkPrivate,
+	container.NetworkVeth:    nspawn.NetworkVeth,
+	container.NetworkBridge:  nspawn.NetworkBridge,
+	container.NetworkMacvlan: nspawn.NetworkMacvlan,
+	container.NetworkIpvlan:  nspawn.NetworkIpvlan,
+}
+
 func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdata) error {
 	var firewall network.Firewall
 
@@ -291,6 +317,14 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 		return err
 	}
 
+	if err := ct.ValidateConfig(); err != nil {
+		return errors.Wrap(err, "invalid container config")
+	}
+
+	if err := ct.ClearHookFailures(); err != nil {
+		return err
+	}
+
 	xdgRuntimeDir, err := getXdgRuntimeDir(usrdata)
 	if err != nil {
 		return err
@@ -303,29 +337,61 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 
 	builder.Quiet = true
 	builder.KeepUnit = true
-	builder.NetworkVeth = ct.Config.VirtualNetwork
-	if ct.Config.VirtualNetwork {
-		builder.NetworkZone, err = network.GenerateUniqueLinkName(ct.Name, nspawn.NetworkZonePrefix)
+	builder.Network = nspawnNetworkModes[ct.Config.Network]
+	builder.NetworkInterface = ct.Config.NetworkInterface
+	if ct.Config.Network == container.NetworkVeth {
+		if ct.Config.SharedNetwork != "" {
+			net, err := network.OpenShared(usrdata, ct.Config.SharedNetwork)
+			if err != nil {
+				return errors.Wrapf(err, "opening shared network for %s", ct.Name)
+			}
+
+			builder.NetworkZone = net.ZoneName(nspawn.NetworkZonePrefix)
+		} else {
+			builder.NetworkZone = network.StableLinkName(ct.Name, ct.MachineName(usrdata),
+				nspawn.NetworkZonePrefix)
+		}
+
+		zoneLink := nspawn.NetworkZonePrefix + builder.NetworkZone
+
+		egress, err := egressPolicy(ct)
 		if err != nil {
-			return errors.Wrapf(err, "generating zone name for %s", ct.Name)
+			return errors.Wrap(err, "preparing egress policy")
 		}
 
 		firewall = network.GetFirewall()
-		if firewall != nil {
+		if firewall == nil && egress.Restrict {
+			return errors.New("egress policies require a firewall backend")
+		} else if firewall != nil {
 			defer func() {
 				if err := firewall.Close(); err != nil {
 					log.Alert("Failed to close firewall:", err)
 				}
 			}()
 
-			if err := firewall.TrustInterface(nspawn.NetworkZonePrefix + builder.NetworkZone); err != nil {
-				log.Alertf("Failed to trust zone %s: %v", builder.NetworkZone, err)
-			} else {
-				defer func() {
-					if err := firewall.UntrustInterface(nspawn.NetworkZonePrefix + builder.NetworkZone); err != nil {
-						log.Alertf("Failed to untrust zone %s: %v", builder.NetworkZone, err)
-					}
-				}()
+			// Registered before adding the interface, so that anything a partially failed
+			// AddInterface left behind is cleaned up as well.
+			defer func() {
+				// nspawn only removes the zone link once the last container in it exits, so
+				// if it's still around, then another container is still using it.
+				if _, err := netlink.LinkByName(zoneLink); err == nil {
+					log.Debugf("Zone %s is still in use, not removing it from the firewall",
+						builder.NetworkZone)
+					return
+				}
+
+				if err := firewall.RemoveInterface(zoneLink); err != nil {
+					log.Alertf("Failed to remove zone %s from firewall: %v", builder.NetworkZone, err)
+				}
+			}()
+
+			if err := firewall.AddInterface(zoneLink, egress); err != nil {
+				if egress.Restrict {
+					// Don't let the container run with a policy that isn't being enforced.
+					return errors.Wrapf(err, "adding zone %s to firewall", builder.NetworkZone)
+				}
+
+				log.Alertf("Failed to add zone %s to firewall: %v", builder.NetworkZone, err)
 			}
 		}
 	}
@@ -428,10 +494,16 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 			return errors.Wrap(err, "mask tmpfiles.d/x11.conf")
 		}
 
-		if ct.Config.VirtualNetwork {
+		if ct.Config.Network.HasLink() {
 			wantsNetworkd := filepath.Join(dataDir, "wants-networkd.conf")
 			builder.AddBindTo(wantsNetworkd, "/etc/systemd/system/nsbox-container.target.d/00-nsbox-networkd.conf")
 		}
+
+		if ct.Config.Network == container.NetworkVeth {
+			// Lets containers in the same zone resolve each other by their hostnames.
+			hostLlmnr := filepath.Join(dataDir, "host0-llmnr.conf")
+			builder.AddBindTo(hostLlmnr, "/etc/systemd/network/80-container-host0.network.d/00-nsbox-llmnr.conf")
+		}
 	} else {
 		// Binding coredumps for a booted container really doesn't make that much sense...
 		builder.AddBind("/var/lib/systemd/coredump")
@@ -497,12 +569,21 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 		return errors.Wrap(err, "failed to write private container files")
 	}
 
-	varlinkListener, err := startVarlinkService(ct, hostPrivPath)
+	// Buffered so the varlink service never blocks on it, even if nspawn already exited.
+	idle := make(chan struct{}, 1)
+
+	varlinkListener, err := startVarlinkService(ct, usrdata, hostPrivPath, func() {
+		if err := runHostHook(ct, usrdata, "post-start", ct.Config.PostStartHook, true); err != nil {
+			log.Alert(err)
+		}
+	}, func() {
+		idle <- struct{}{}
+	})
 	if err != nil {
 		return err
 	}
 
-	defer (*varlinkListener).Close()
+	defer varlinkListener.Close()
 
 	if ct.Config.Boot {
 		builder.Command = []string{"--", "--unit=nsbox-container.target"}
@@ -510,10 +591,18 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 		builder.Command = []string{"/run/host/nsbox/scripts/nsbox-init.sh"}
 	}
 
-	nspawnArgs := builder.Build()
+	nspawnArgs, err := builder.Build()
+	if err != nil {
+		return err
+	}
 
 	log.Debug("running:", nspawnArgs)
 
+	// Run this before the exec context is set, so the hook doesn't end up with it.
+	if err := runHostHook(ct, usrdata, "pre-start", ct.Config.PreStartHook, false); err != nil {
+		return err
+	}
+
 	if err := selinux.SetExecProcessContextContainer(); err != nil {
 		log.Alert("failed to set exec context:", err)
 	}
@@ -538,9 +627,42 @@ func RunContainerDirectNspawn(ct *container.Container, usrdata *userdata.Userdat
 		Pdeathsig: unix.SIGTERM,
 	}
 
-	if err := nspawnCmd.Run(); err != nil {
+	// Catch termination signals and pass them onto nspawn, so this process stays alive long
+	// enough for the deferred cleanups (e.g. firewall rules) to run once nspawn exits.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT)
+	defer signal.Stop(signals)
+
+	if err := nspawnCmd.Start(); err != nil {
 		return err
 	}
 
-	return nil
+	exited := make(chan struct{})
+	defer close(exited)
+
+	go func() {
+		for {
+			var sig os.Signal
+			select {
+			case sig = <-signals:
+			case <-idle:
+				// nspawn handles SIGTERM by shutting down the container cleanly.
+				sig = unix.SIGTERM
+			case <-exited:
+				return
+			}
+
+			if err := nspawnCmd.Process.Signal(sig); err != nil {
+				log.Debug("Failed to forward signal to nspawn:", err)
+			}
+		}
+	}()
+
+	waitErr := nspawnCmd.Wait()
+
+	if err := runHostHook(ct, usrdata, "post-stop", ct.Config.PostStopHook, false); err != nil {
+		log.Alert(err)
+	}
+
+	return waitErr
 }
@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/v22/machine1"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+const (
+	machinedService       = "org.freedesktop.machine1"
+	machinedManagerObject = "/org/freedesktop/machine1"
+
+	machinedGetMachineAddressesMethod = "org.freedesktop.machine1.Manager.GetMachineAddresses"
+)
+
+type machineAddress struct {
+	Family  int32
+	Address []byte
+}
+
+// Returns the IP addresses of the given machine, as reported by machined.
+func getMachineAddresses(machineName string) ([]string, error) {
+	systemBus, err := godbus.SystemBus()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to system bus")
+	}
+
+	var addresses []machineAddress
+	object := systemBus.Object(machinedService, machinedManagerObject)
+	if err := object.Call(machinedGetMachineAddressesMethod, 0, machineName).Store(&addresses); err != nil {
+		return nil, errors.Wrap(err, "failed to get machine addresses")
+	}
+
+	result := []string{}
+	for _, address := range addresses {
+		result = append(result, net.IP(address.Address).String())
+	}
+
+	return result, nil
+}
+
+// Returns the PID of the machine's leader process.
+func getMachineLeader(machineName string) (uint32, error) {
+	machined, err := machine1.New()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to connect to machined")
+	}
+
+	props, err := machined.DescribeMachine(machineName)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to describe machine")
+	}
+
+	leader, ok := props["Leader"].(uint32)
+	if !ok {
+		return 0, errors.New("machine has no leader")
+	}
+
+	return leader, nil
+}
+
+// Runs one of the container's host hooks, if it was set. If running is true, then the
+// container's leader PID and IP addresses are passed to the hook as well.
+func runHostHook(ct *container.Container, usrdata *userdata.Userdata, hook, path string, running bool) error {
+	if path == "" {
+		return nil
+	}
+
+	machineName := ct.MachineName(usrdata)
+
+	// Hooks come from the container's config, which the user can change without any extra
+	// privileges, so they're run as the user, and without any of nsboxd's environment.
+	cmd := exec.Command(path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = usrdata.User.HomeDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: usrdata.Credential()}
+
+	cmd.Env = usrdata.HostEnviron()
+	cmd.Env = append(cmd.Env, "NSBOX_HOOK="+hook)
+	cmd.Env = append(cmd.Env, "NSBOX_CONTAINER="+ct.Name)
+	cmd.Env = append(cmd.Env, "NSBOX_MACHINE="+machineName)
+
+	if running {
+		if leader, err := getMachineLeader(machineName); err != nil {
+			log.Alert("Failed to get container leader for hook:", err)
+		} else {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("NSBOX_LEADER=%d", leader))
+		}
+
+		if addresses, err := getMachineAddresses(machineName); err != nil {
+			log.Alert("Failed to get container addresses for hook:", err)
+		} else {
+			cmd.Env = append(cmd.Env, "NSBOX_IPS="+strings.Join(addresses, " "))
+		}
+	}
+
+	log.Debugf("running %s hook: %s", hook, path)
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s hook %s failed", hook, path)
+	}
+
+	return nil
+}
@@ -2,12 +2,15 @@
  * License, v. 2.0. If a copy of the MPL was not distributed with this
  * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
 
-// Run a container indirectly, by starting a transient systemd service that runs nsboxd.
+// Run a container indirectly, by starting a transient systemd service that runs nsboxd (or the
+// persistent one, if the container was enabled).
 package daemon
 
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	systemd1 "github.com/coreos/go-systemd/v22/dbus"
@@ -26,49 +29,87 @@ import (
 
 type temporaryFileSystem struct{ Path, Options string }
 
-func startNsboxd(systemd *systemd1.Conn, nsboxd string, ct *container.Container, usrdata *userdata.Userdata) error {
-	serviceName := fmt.Sprintf("nsbox-%s.service", ct.MachineName(usrdata))
+const (
+	restartDelay = 5 * time.Second
+	// On systemd versions that support it, the restart delay grows in this many steps up to
+	// restartMaxDelay, so a container that keeps failing doesn't spin.
+	restartSteps    = 5
+	restartMaxDelay = 5 * time.Minute
 
-	journal, err := sdjournal.NewJournalReader(sdjournal.JournalReaderConfig{
-		// XXX: use a 1-nanosecond duration to get it to filter starting now.
-		// If it's 0, then NewJournalReader will think it's completely unset.
-		Since: 1,
-		Matches: []sdjournal.Match{
-			{
-				Field: sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT,
-				Value: serviceName,
-			},
-		},
-		Formatter: func(entry *sdjournal.JournalEntry) (string, error) {
-			msg, ok := entry.Fields["MESSAGE"]
-			if !ok {
-				return "", errors.Errorf("Journal entry had no MESSAGE field")
-			}
+	// The first systemd version with RestartSteps / RestartMaxDelaySec.
+	restartStepsSystemdVersion = 254
+)
 
-			return fmt.Sprintln(msg), nil
-		},
-	})
+// Describes the service that runs nsboxd for a container. This is normally started as a
+// transient unit, but it's installed as a persistent unit once the container is enabled.
+type nsboxdService struct {
+	name         string
+	description  string
+	execStart    []string
+	environment  []string
+	requires     []string
+	restart      container.RestartPolicy
+	restartSteps bool
+}
 
+func systemdVersion(systemd *systemd1.Conn) int {
+	value, err := systemd.GetManagerProperty("Version")
 	if err != nil {
-		return errors.Wrap(err, "opening journal reader")
+		log.Debug("Failed to get systemd version:", err)
+		return 0
 	}
 
-	// If a unit reset failed, it likely just never was running.
-	_ = systemd.ResetFailedUnit(serviceName)
+	// The version is quoted, and may have a distro-specific suffix (e.g. "254.5-1.fc39").
+	value = strings.Trim(value, `"`)
+	if end := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' }); end != -1 {
+		value = value[:end]
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		log.Debug("Failed to parse systemd version:", err)
+		return 0
+	}
+
+	return version
+}
+
+func newNsboxdService(systemd *systemd1.Conn, ct *container.Container, usrdata *userdata.Userdata) (*nsboxdService, error) {
+	nsboxd, err := paths.GetPrivateExecutable("nsboxd")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot locate nsboxd")
+	}
 
 	xdgRuntimeDir, err := getXdgRuntimeDir(usrdata)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	service := &nsboxdService{
+		name:        ct.ServiceName(usrdata),
+		description: fmt.Sprintf("nsbox container %s for %s", ct.Name, usrdata.User.Username),
+		execStart:   []string{nsboxd, fmt.Sprint("-v=", log.Verbose()), ct.Name},
+		environment: []string{"PKEXEC_UID=" + usrdata.User.Uid, "XDG_RUNTIME_DIR=" + xdgRuntimeDir},
+		restart:     ct.Config.Restart,
+	}
+
+	if ct.Config.Network == container.NetworkVeth {
+		// The host's networkd is responsible for configuring the zone bridge.
+		service.requires = append(service.requires, "systemd-networkd.service")
+	}
+
+	if service.restart != container.RestartNo {
+		service.restartSteps = systemdVersion(systemd) >= restartStepsSystemdVersion
 	}
-	env := append([]string{"PKEXEC_UID=" + usrdata.User.Uid, "XDG_RUNTIME_DIR=" + xdgRuntimeDir})
 
+	return service, nil
+}
+
+func (service *nsboxdService) transientProperties() []systemd1.Property {
 	properties := []systemd1.Property{
 		systemd1.PropType("notify"),
-		systemd1.PropDescription(fmt.Sprintf("nsbox container %s for %s", ct.Name, usrdata.User.Username)),
-		systemd1.PropExecStart(
-			[]string{nsboxd, fmt.Sprint("-v=", log.Verbose()), ct.Name},
-			false,
-		),
+		systemd1.PropDescription(service.description),
+		systemd1.PropExecStart(service.execStart, false),
 		{
 			// This is needed for safety with use of nsbus, see there for more info.
 			Name:  "TemporaryFileSystem",
@@ -76,7 +117,7 @@ func startNsboxd(systemd *systemd1.Conn, nsboxd string, ct *container.Container,
 		},
 		{
 			Name:  "Environment",
-			Value: godbus.MakeVariant(env),
+			Value: godbus.MakeVariant(service.environment),
 		},
 		{
 			Name:  "NotifyAccess",
@@ -84,16 +125,125 @@ func startNsboxd(systemd *systemd1.Conn, nsboxd string, ct *container.Container,
 		},
 	}
 
-	if ct.Config.VirtualNetwork {
-		properties = append(properties, systemd1.PropRequires("systemd-networkd.service"))
+	for _, unit := range service.requires {
+		properties = append(properties, systemd1.PropRequires(unit))
+	}
+
+	if service.restart != container.RestartNo {
+		properties = append(properties,
+			systemd1.Property{
+				Name:  "Restart",
+				Value: godbus.MakeVariant(service.restart.String()),
+			},
+			systemd1.Property{
+				Name:  "RestartUSec",
+				Value: godbus.MakeVariant(uint64(restartDelay / time.Microsecond)),
+			})
+
+		if service.restartSteps {
+			properties = append(properties,
+				systemd1.Property{
+					Name:  "RestartSteps",
+					Value: godbus.MakeVariant(uint32(restartSteps)),
+				},
+				systemd1.Property{
+					Name:  "RestartMaxDelayUSec",
+					Value: godbus.MakeVariant(uint64(restartMaxDelay / time.Microsecond)),
+				})
+		}
+	}
+
+	return properties
+}
+
+// Messages the shell and exec print when running a program fails, after the program's path.
+var execFailureMessages = []string{
+	"no such file or directory",
+	"permission denied",
+	"cannot execute",
+	"bad interpreter",
+	"exec format error",
+	"not found",
+}
+
+// Checks if the lowercased log has an error from trying to run the given path.
+func execFailedInLog(lowerLog, path string) bool {
+	prefix := strings.ToLower(path) + ": "
+	for _, message := range execFailureMessages {
+		if strings.Contains(lowerLog, prefix+message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Prints the failure the container's init reported, along with hints for the common causes.
+// shell is the login shell that the container was started with.
+func showStartFailure(failure *container.StartFailure, shell string) {
+	log.Alertf("Container init failed during the %s stage: %s", failure.Stage, failure.Message)
+
+	if failure.Log != "" {
+		log.Alert("Init output:")
+		for _, line := range strings.Split(strings.TrimRight(failure.Log, "\n"), "\n") {
+			log.Alert("  " + line)
+		}
+	}
+
+	lowerLog := strings.ToLower(failure.Log)
+
+	if selinux.Enforcing() && (strings.Contains(lowerLog, "permission denied") || strings.Contains(lowerLog, "avc")) {
+		log.Alert("HINT: This looks like an SELinux denial; check 'ausearch -m avc -ts recent'.")
+		log.Alert("If setting SELinux to permissive works, please file a bug report with nsbox.")
+	}
+
+	if failure.Stage == "user" {
+		if strings.Contains(lowerLog, "useradd") || strings.Contains(lowerLog, "usermod") {
+			if strings.Contains(lowerLog, "command not found") {
+				log.Alert("HINT: The container image has no useradd / usermod; install the shadow-utils",
+					"(or passwd) package in the image.")
+			}
+		}
+	}
+
+	if execFailedInLog(lowerLog, shell) {
+		log.Alertf("HINT: The shell %s failed to run in the container; try reinstalling it inside"+
+			" the container's image.", shell)
+	}
+}
+
+// Starts the service via the given function, following its journal output until the start
+// job completes.
+func startServiceWithJournal(ct *container.Container, usrdata *userdata.Userdata, serviceName string, start func(jobStatus chan<- string) error) error {
+	journal, err := sdjournal.NewJournalReader(sdjournal.JournalReaderConfig{
+		// XXX: use a 1-nanosecond duration to get it to filter starting now.
+		// If it's 0, then NewJournalReader will think it's completely unset.
+		Since: 1,
+		Matches: []sdjournal.Match{
+			{
+				Field: sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT,
+				Value: serviceName,
+			},
+		},
+		Formatter: func(entry *sdjournal.JournalEntry) (string, error) {
+			msg, ok := entry.Fields["MESSAGE"]
+			if !ok {
+				return "", errors.Errorf("Journal entry had no MESSAGE field")
+			}
+
+			return fmt.Sprintln(msg), nil
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "opening journal reader")
 	}
 
 	journalUntil := make(chan time.Time)
 	jobStatus := make(chan string)
 
-	_, err = systemd.StartTransientUnit(serviceName, "replace", properties, jobStatus)
-	if err != nil {
-		return errors.Wrap(err, "starting transient unit")
+	if err := start(jobStatus); err != nil {
+		return err
 	}
 
 	go func() {
@@ -108,6 +258,14 @@ func startNsboxd(systemd *systemd1.Conn, nsboxd string, ct *container.Container,
 	journalUntil <- time.Now()
 
 	if jobResult != "done" {
+		failure, err := ct.LoadStartFailure()
+		if err != nil {
+			log.Debug("loading start failure:", err)
+		} else if failure != nil {
+			showStartFailure(failure, ct.Shell(usrdata))
+			return errors.Errorf("container init failed during the %s stage", failure.Stage)
+		}
+
 		if selinux.Enforcing() {
 			log.Alert("NOTE: If there is a permission denied error, try setting SELinux to permissive.")
 			log.Alert("If that works, please file a bug report with nsbox.")
@@ -115,9 +273,53 @@ func startNsboxd(systemd *systemd1.Conn, nsboxd string, ct *container.Container,
 		return fmt.Errorf("job failed (see 'systemctl status %s' for more info)", serviceName)
 	}
 
+	// The container started, but if any of its own on-start hooks failed, the user should know.
+	failures, err := ct.LoadHookFailures()
+	if err != nil {
+		log.Debug("loading hook failures:", err)
+	}
+
+	for _, failure := range failures {
+		log.Alertf("WARNING: container %s hook %s failed with status %d", failure.Hook, failure.Path,
+			failure.Status)
+	}
 	return nil
 }
 
+func startNsboxd(systemd *systemd1.Conn, ct *container.Container, usrdata *userdata.Userdata) error {
+	serviceName := ct.ServiceName(usrdata)
+
+	// If a unit reset failed, it likely just never was running.
+	_ = systemd.ResetFailedUnit(serviceName)
+
+	if err := ct.ClearStartFailure(); err != nil {
+		return err
+	}
+
+	enabled, err := IsContainerEnabled(ct, usrdata)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		// The persistent unit takes the transient unit's place.
+		return startServiceWithJournal(ct, usrdata, serviceName, func(jobStatus chan<- string) error {
+			_, err := systemd.StartUnit(serviceName, "replace", jobStatus)
+			return errors.Wrap(err, "starting unit")
+		})
+	}
+
+	service, err := newNsboxdService(systemd, ct, usrdata)
+	if err != nil {
+		return err
+	}
+
+	return startServiceWithJournal(ct, usrdata, serviceName, func(jobStatus chan<- string) error {
+		_, err := systemd.StartTransientUnit(serviceName, "replace", service.transientProperties(), jobStatus)
+		return errors.Wrap(err, "starting transient unit")
+	})
+}
+
 func RunContainerViaTransientUnit(ct *container.Container, restart bool, usrdata *userdata.Userdata) error {
 	ct.ApplyEnvironFilter(usrdata)
 
@@ -135,17 +337,15 @@ func RunContainerViaTransientUnit(ct *container.Container, restart bool, usrdata
 		if _, err := machined.GetMachine(ct.MachineName(usrdata)); err == nil {
 			log.Debug("Killing previous container instance")
 
-			var signal kill.Signal
-			var all bool
+			// Booted containers get the chance to shut down cleanly, but there's nothing in a
+			// non-booted one worth waiting for.
 			if ct.Config.Boot {
-				signal = kill.SigPoweroff
-				all = false
+				err = kill.StopContainer(usrdata, ct, kill.DefaultTimeout)
 			} else {
-				signal = kill.SigKill
-				all = true
+				err = kill.KillContainer(usrdata, ct, kill.SigKill, true, kill.DefaultTimeout)
 			}
 
-			if err := kill.KillContainer(usrdata, ct, signal, all); err != nil {
+			if err != nil {
 				return errors.Wrap(err, "killing previous instance")
 			}
 		}
@@ -154,13 +354,16 @@ func RunContainerViaTransientUnit(ct *container.Container, restart bool, usrdata
 	if _, err := machined.GetMachine(ct.MachineName(usrdata)); err != nil {
 		log.Debug("GetMachine:", err)
 
-		nsboxd, err := paths.GetPrivateExecutable("nsboxd")
-		if err != nil {
-			return errors.Wrap(err, "cannot locate nsboxd")
+		if err := startNsboxd(systemd, ct, usrdata); err != nil {
+			return errors.Wrap(err, "cannot start nsboxd")
 		}
+	} else if paused, err := ct.IsPaused(usrdata); err != nil {
+		return errors.Wrap(err, "checking if the container is paused")
+	} else if paused {
+		log.Info("Resuming paused container", ct.Name)
 
-		if err := startNsboxd(systemd, nsboxd, ct, usrdata); err != nil {
-			return errors.Wrap(err, "cannot start nsboxd")
+		if err := ct.Resume(usrdata); err != nil {
+			return errors.Wrap(err, "resuming container")
 		}
 	}
 
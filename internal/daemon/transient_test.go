@@ -0,0 +1,34 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package daemon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecFailedInLog(t *testing.T) {
+	for _, test := range []struct {
+		log      string
+		shell    string
+		expected bool
+	}{
+		{log: "bash: /usr/bin/zsh: No such file or directory", shell: "/usr/bin/zsh", expected: true},
+		{log: "/usr/bin/fish: cannot execute binary file", shell: "/usr/bin/fish", expected: true},
+		{log: "/bin/sh: /usr/bin/zsh: bad interpreter: No such file", shell: "/usr/bin/zsh", expected: true},
+		{log: "exec: /usr/bin/zsh: not found", shell: "/usr/bin/zsh", expected: true},
+
+		// Mentions of shells that aren't failures to run the configured one.
+		{log: "useradd: warning: the shell /usr/bin/zsh is not in /etc/shells", shell: "/usr/bin/zsh"},
+		{log: "bash: /usr/bin/zsh: No such file or directory", shell: "/bin/bash"},
+		{log: "/usr/bin/zsh-static: not found", shell: "/usr/bin/zsh"},
+		{log: "Setting up shell integration", shell: "/bin/bash"},
+		{log: "", shell: "/bin/bash"},
+	} {
+		if result := execFailedInLog(strings.ToLower(test.log), test.shell); result != test.expected {
+			t.Errorf("%q with shell %s: expected %t, got %t", test.log, test.shell, test.expected, result)
+		}
+	}
+}
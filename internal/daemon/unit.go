@@ -0,0 +1,203 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Install the nsboxd service persistently, so enabled containers start on boot.
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	systemd1 "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/nsbus"
+	"github.com/refi64/nsbox/internal/paths"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+const enabledTarget = "multi-user.target"
+
+func persistentUnitPath(ct *container.Container, usrdata *userdata.Userdata) string {
+	return filepath.Join(paths.SystemdUnitDir, ct.ServiceName(usrdata))
+}
+
+func IsContainerEnabled(ct *container.Container, usrdata *userdata.Userdata) (bool, error) {
+	if _, err := os.Stat(persistentUnitPath(ct, usrdata)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "checking for persistent unit")
+	}
+
+	return true, nil
+}
+
+// Quotes a single argument or assignment the way systemd's unit file parser expects.
+func quoteUnitValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func formatUnitSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%ds", int64(duration/time.Second))
+}
+
+func (service *nsboxdService) unitFile(usrdata *userdata.Userdata) string {
+	var builder strings.Builder
+
+	fmt.Fprintln(&builder, "# Generated by nsbox, do not edit. Use 'nsbox disable' to remove it.")
+
+	fmt.Fprintln(&builder, "[Unit]")
+	fmt.Fprintln(&builder, "Description="+service.description)
+	for _, unit := range service.requires {
+		fmt.Fprintln(&builder, "Requires="+unit)
+	}
+
+	// On boot, the user's runtime directory won't exist until they log in, so make sure it
+	// gets created beforehand.
+	runtimeDirUnit := fmt.Sprintf("user-runtime-dir@%s.service", usrdata.User.Uid)
+	fmt.Fprintln(&builder, "Wants="+runtimeDirUnit)
+	fmt.Fprintln(&builder, "After="+runtimeDirUnit)
+
+	fmt.Fprintln(&builder)
+	fmt.Fprintln(&builder, "[Service]")
+	fmt.Fprintln(&builder, "Type=notify")
+	fmt.Fprintln(&builder, "NotifyAccess=all")
+
+	execStart := []string{}
+	for _, arg := range service.execStart {
+		execStart = append(execStart, quoteUnitValue(arg))
+	}
+
+	fmt.Fprintln(&builder, "ExecStart="+strings.Join(execStart, " "))
+
+	for _, env := range service.environment {
+		fmt.Fprintln(&builder, "Environment="+quoteUnitValue(env))
+	}
+
+	// This is needed for safety with use of nsbus, see there for more info.
+	fmt.Fprintln(&builder, "TemporaryFileSystem="+nsbus.PrivateBusTmpdir)
+
+	if service.restart != container.RestartNo {
+		fmt.Fprintln(&builder, "Restart="+service.restart.String())
+		fmt.Fprintln(&builder, "RestartSec="+formatUnitSeconds(restartDelay))
+
+		if service.restartSteps {
+			fmt.Fprintln(&builder, "RestartSteps="+fmt.Sprint(restartSteps))
+			fmt.Fprintln(&builder, "RestartMaxDelaySec="+formatUnitSeconds(restartMaxDelay))
+		}
+	}
+
+	fmt.Fprintln(&builder)
+	fmt.Fprintln(&builder, "[Install]")
+	fmt.Fprintln(&builder, "WantedBy="+enabledTarget)
+
+	return builder.String()
+}
+
+func writePersistentUnit(systemd *systemd1.Conn, ct *container.Container, usrdata *userdata.Userdata) error {
+	service, err := newNsboxdService(systemd, ct, usrdata)
+	if err != nil {
+		return err
+	}
+
+	unitPath := persistentUnitPath(ct, usrdata)
+	tempUnitPath := unitPath + ".tmp"
+
+	if err := ioutil.WriteFile(tempUnitPath, []byte(service.unitFile(usrdata)), 0644); err != nil {
+		return errors.Wrap(err, "failed to write temporary unit")
+	}
+
+	if err := os.Rename(tempUnitPath, unitPath); err != nil {
+		return errors.Wrap(err, "failed to overwrite unit")
+	}
+
+	return errors.Wrap(systemd.Reload(), "reloading systemd")
+}
+
+// Installs and enables a persistent unit for the container. If now is true, the container
+// will also be started.
+func EnableContainer(ct *container.Container, usrdata *userdata.Userdata, now bool) error {
+	ct.ApplyEnvironFilter(usrdata)
+
+	systemd, err := systemd1.NewSystemConnection()
+	if err != nil {
+		return err
+	}
+
+	defer systemd.Close()
+
+	if err := writePersistentUnit(systemd, ct, usrdata); err != nil {
+		return err
+	}
+
+	serviceName := ct.ServiceName(usrdata)
+	if _, _, err := systemd.EnableUnitFiles([]string{serviceName}, false, false); err != nil {
+		return errors.Wrap(err, "enabling unit")
+	}
+
+	// Like systemctl enable, reload so systemd picks up the new install symlinks.
+	if err := systemd.Reload(); err != nil {
+		return errors.Wrap(err, "reloading systemd")
+	}
+
+	if now {
+		return RunContainerViaTransientUnit(ct, false, usrdata)
+	}
+
+	return nil
+}
+
+// Rewrites the container's persistent unit if it's enabled, e.g. after its config was changed.
+func UpdateEnabledContainer(ct *container.Container, usrdata *userdata.Userdata) error {
+	enabled, err := IsContainerEnabled(ct, usrdata)
+	if err != nil || !enabled {
+		return err
+	}
+
+	ct.ApplyEnvironFilter(usrdata)
+
+	systemd, err := systemd1.NewSystemConnection()
+	if err != nil {
+		return err
+	}
+
+	defer systemd.Close()
+
+	return writePersistentUnit(systemd, ct, usrdata)
+}
+
+// Disables and removes the container's persistent unit. A running container is left running.
+func DisableContainer(ct *container.Container, usrdata *userdata.Userdata) error {
+	enabled, err := IsContainerEnabled(ct, usrdata)
+	if err != nil {
+		return err
+	} else if !enabled {
+		return errors.Errorf("container %s is not enabled", ct.Name)
+	}
+
+	systemd, err := systemd1.NewSystemConnection()
+	if err != nil {
+		return err
+	}
+
+	defer systemd.Close()
+
+	serviceName := ct.ServiceName(usrdata)
+	if _, err := systemd.DisableUnitFiles([]string{serviceName}, false); err != nil {
+		return errors.Wrap(err, "disabling unit")
+	}
+
+	if err := os.Remove(persistentUnitPath(ct, usrdata)); err != nil {
+		return errors.Wrap(err, "removing unit")
+	}
+
+	return errors.Wrap(systemd.Reload(), "reloading systemd")
+}
@@ -0,0 +1,35 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Turns the results of waiting for commands into the exit statuses nsbox reports for them.
+package exitstatus
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/refi64/nsbox/internal/log"
+)
+
+// Returns the exit status for the error returned by exec.Cmd.Wait. Commands killed by a signal
+// get the same status a shell would give them, and errors that aren't from the command itself
+// exiting are logged and treated as a failure.
+func FromWaitError(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		waitStatus := exitErr.Sys().(syscall.WaitStatus)
+		if waitStatus.Signaled() {
+			// Mimic the shell's exit code on signal.
+			return 128 + int(waitStatus.Signal())
+		}
+
+		return waitStatus.ExitStatus()
+	}
+
+	log.Alert("failed to wait for command:", err)
+	return 1
+}
@@ -0,0 +1,34 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package exitstatus
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestFromWaitError(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+		{"kill -KILL $$", 128 + 9},
+	}
+
+	for _, test := range tests {
+		err := exec.Command("sh", "-c", test.script).Run()
+		if status := FromWaitError(err); status != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.script, test.expected, status)
+		}
+	}
+
+	if status := FromWaitError(errors.New("not started")); status != 1 {
+		t.Errorf("expected 1 for a non-exit error, got %d", status)
+	}
+}
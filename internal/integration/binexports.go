@@ -0,0 +1,209 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/config"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/log"
+)
+
+var (
+	// BinExports patterns without a slash match binaries in these directories, in order of
+	// precedence.
+	binDirs = []string{"/usr/local/bin", "/usr/bin"}
+
+	// Binaries are exported under their own names and written into shell wrappers, so their
+	// paths are limited to characters that are safe in file names and scripts alike.
+	safeBinaryPathRe = regexp.MustCompile(`^[a-zA-Z0-9/._+@,=-]+$`)
+)
+
+// Checks if the binary at the given in-container path can be exported without its name being
+// mistaken for something else, e.g. an option or a hidden file.
+func isSafeBinaryPath(path string) bool {
+	name := filepath.Base(path)
+	return safeBinaryPathRe.MatchString(path) && !strings.HasPrefix(name, "-") &&
+		!strings.HasPrefix(name, ".")
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Checks if the path, relative to the host, looks like something that can be run. Symlinks are
+// trusted as-is, as they may well be absolute links that only resolve inside the container.
+func isExportableBinary(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		log.Debugf("failed to stat %s: %v", path, err)
+		return false
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return true
+	}
+
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// Checks if the given path, relative to the host, is still inside the container's storage once
+// any symlinks in it are resolved. The host resolves absolute symlinks against its own root, so
+// e.g. a directory symlinked to / inside the container would otherwise lead onto the host.
+func (ctx *exportContext) resolvesInsideStorage(path string) bool {
+	storage, err := filepath.EvalSymlinks(ctx.ct.StorageChild())
+	if err != nil {
+		log.Debug("failed to resolve container storage:", err)
+		return false
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		log.Debugf("failed to resolve %s: %v", path, err)
+		return false
+	}
+
+	return resolved == storage || strings.HasPrefix(resolved, storage+"/")
+}
+
+// Returns the in-container paths of every binary matching the given pattern.
+func (ctx *exportContext) matchBinaries(pattern string) []string {
+	if filepath.IsAbs(pattern) {
+		// Cleaning an absolute path drops any leading .., so it can't climb out of the storage.
+		matches, err := filepath.Glob(ctx.ct.StorageChild(filepath.Clean(pattern)))
+		if err != nil {
+			log.Alertf("%s failed to match: %v", pattern, err)
+			return nil
+		}
+
+		var binaries []string
+		for _, match := range matches {
+			// The binary itself may be an absolute symlink that only makes sense inside the
+			// container, but the directories leading up to it have to stay inside.
+			if !ctx.resolvesInsideStorage(filepath.Dir(match)) {
+				log.Alertf("not exporting %s, as it's outside of the container", match)
+				continue
+			}
+
+			if !isExportableBinary(match) {
+				continue
+			}
+
+			rel, err := filepath.Rel(ctx.ct.StorageChild(), match)
+			if err != nil {
+				log.Alertf("could not make %s relative to the container root: %v", match, err)
+				continue
+			}
+
+			binaries = append(binaries, "/"+rel)
+		}
+
+		return binaries
+	}
+
+	var binaries []string
+	for _, dir := range binDirs {
+		if _, err := os.Lstat(ctx.ct.StorageChild(dir)); os.IsNotExist(err) {
+			continue
+		} else if !ctx.resolvesInsideStorage(ctx.ct.StorageChild(dir)) {
+			log.Alertf("not exporting from %s, as it's outside of the container", dir)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(ctx.ct.StorageChild(dir))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Alertf("failed to read %s: %v", dir, err)
+			}
+
+			continue
+		}
+
+		for _, entry := range entries {
+			ok, err := filepath.Match(pattern, entry.Name())
+			if err != nil {
+				log.Alertf("%s failed to match: %v", pattern, entry.Name())
+				return nil
+			} else if ok && isExportableBinary(ctx.ct.StorageChild(dir, entry.Name())) {
+				binaries = append(binaries, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	return binaries
+}
+
+func (ctx *exportContext) exportBinary(binary string) error {
+	log.Debug("Exporting binary", binary)
+
+	wrapperPath := filepath.Join(ctx.targetBinDir, filepath.Base(binary))
+	// Like desktop files, wrappers shouldn't replay, so they can be used in scripts and pipes.
+	// The binary path was checked by isSafeBinaryPath and container names are validated on
+	// creation, so neither can break out of the comment.
+	wrapper := fmt.Sprintf("#!/bin/sh\n# Generated by %s, runs %s in the container %s.\nexec %s run -no-replay -- %s %s \"$@\"\n",
+		config.ProductName, binary, ctx.ct.Name, config.ProductName, shellQuote(ctx.ct.Name), shellQuote(binary))
+
+	if err := ioutil.WriteFile(wrapperPath, []byte(wrapper), 0755); err != nil {
+		return errors.Wrapf(err, "failed to write wrapper for %s", binary)
+	}
+
+	return nil
+}
+
+func (ctx *exportContext) exportBinaries() {
+	// Earlier patterns and directories win when multiple binaries have the same name.
+	exported := map[string]string{}
+
+	for _, pattern := range ctx.ct.Config.BinExports {
+		for _, binary := range ctx.matchBinaries(pattern) {
+			if !isSafeBinaryPath(binary) {
+				log.Alertf("not exporting %q, as its name has unsafe characters", binary)
+				continue
+			}
+
+			name := filepath.Base(binary)
+			if previous, ok := exported[name]; ok {
+				if previous != binary {
+					log.Debugf("not exporting %s, as %s was already exported", binary, previous)
+				}
+
+				continue
+			}
+
+			if err := ctx.exportBinary(binary); err != nil {
+				log.Alertf("failed to export %s: %v", binary, err)
+				continue
+			}
+
+			exported[name] = binary
+		}
+	}
+}
+
+// Returns the names of the binaries currently exported from the container.
+func ExportedBinaries(ct *container.Container) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(ct.ExportsLink(false), "bin"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read exported binaries")
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
@@ -0,0 +1,117 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package integration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/refi64/nsbox/internal/container"
+)
+
+func TestIsSafeBinaryPath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		safe bool
+	}{
+		{path: "/usr/bin/rg", safe: true},
+		{path: "/usr/bin/g++", safe: true},
+		{path: "/usr/bin/x86_64-linux-gnu-gcc-10", safe: true},
+		{path: "/opt/My.App/bin/my@app,v=2", safe: true},
+
+		{path: "/usr/bin/foo\nrm -rf ~", safe: false},
+		{path: "/usr/bin/foo\rbar", safe: false},
+		{path: "/usr/bin/foo\x1b[2J", safe: false},
+		{path: "/usr/bin/foo bar", safe: false},
+		{path: "/usr/bin/$(reboot)", safe: false},
+		{path: "/usr/bin/`reboot`", safe: false},
+		{path: "/usr/bin/foo;reboot", safe: false},
+		{path: "/usr/bin/it's", safe: false},
+		{path: "/usr/bin/*", safe: false},
+		{path: "/usr/bin/-rf", safe: false},
+		{path: "/usr/bin/.hidden", safe: false},
+		{path: "/opt/my app/bin/app", safe: false},
+	} {
+		if safe := isSafeBinaryPath(test.path); safe != test.safe {
+			t.Errorf("%q: expected %t, got %t", test.path, test.safe, safe)
+		}
+	}
+}
+
+func writeTestBinary(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchBinaries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsbox-integration-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ct := &container.Container{Name: "test", Path: filepath.Join(dir, "container")}
+	ctx := &exportContext{ct: ct}
+
+	writeTestBinary(t, ct.StorageChild("usr/bin/rg"))
+	writeTestBinary(t, ct.StorageChild("usr/bin/cargo"))
+	writeTestBinary(t, ct.StorageChild("usr/local/bin/cargo-watch"))
+	writeTestBinary(t, ct.StorageChild("opt/app/bin/app"))
+
+	// Not executable, so not exported.
+	if err := ioutil.WriteFile(ct.StorageChild("usr/bin/cargo.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Something on the "host", which patterns should never be able to reach.
+	writeTestBinary(t, filepath.Join(dir, "host/bin/secret"))
+
+	for link, target := range map[string]string{
+		// Leads onto the host when resolved from outside the container.
+		"opt/escape": filepath.Join(dir, "host"),
+		// Stays inside the container.
+		"opt/current": "app",
+		// Absolute symlinks only make sense inside the container, so they're trusted as-is.
+		"usr/bin/rg-link": "/usr/bin/rg",
+	} {
+		if err := os.Symlink(target, ct.StorageChild(link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{pattern: "rg", expected: []string{"/usr/bin/rg"}},
+		{pattern: "cargo*", expected: []string{"/usr/bin/cargo", "/usr/local/bin/cargo-watch"}},
+		{pattern: "rg*", expected: []string{"/usr/bin/rg", "/usr/bin/rg-link"}},
+		{pattern: "missing"},
+		{pattern: "/opt/app/bin/app", expected: []string{"/opt/app/bin/app"}},
+		{pattern: "/opt/*/bin/*", expected: []string{"/opt/app/bin/app", "/opt/current/bin/app"}},
+		{pattern: "/opt/escape/bin/*"},
+		{pattern: "/../../host/bin/*"},
+		{pattern: "/opt/../../../host/bin/secret"},
+	} {
+		binaries := ctx.matchBinaries(test.pattern)
+		sort.Strings(binaries)
+
+		if len(binaries) != 0 || len(test.expected) != 0 {
+			if !reflect.DeepEqual(binaries, test.expected) {
+				t.Errorf("%s: expected %v, got %v", test.pattern, test.expected, binaries)
+			}
+		}
+	}
+}
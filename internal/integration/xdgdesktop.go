@@ -7,6 +7,7 @@ package integration
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -49,6 +50,7 @@ type exportContext struct {
 	targetRoot            string
 	targetApplicationsDir string
 	targetIconsDir        string
+	targetBinDir          string
 }
 
 func (ctx *exportContext) Destroy() {
@@ -243,6 +245,9 @@ func (ctx *exportContext) exportIcons() {
 	}
 }
 
+// Regenerates everything exported from the container: its desktop files and icons, along with
+// wrappers for its BinExports. The previous exports are swapped out and removed in one go, so
+// anything that's no longer exported disappears with them.
 func UpdateDesktopFiles(ct *container.Container) error {
 	lock, err := ct.Lock(container.ExportsLock, container.NoWaitForLock)
 	if err != nil {
@@ -297,8 +302,9 @@ func UpdateDesktopFiles(ct *container.Container) error {
 
 	ctx.targetApplicationsDir = filepath.Join(ctx.targetRoot, "applications")
 	ctx.targetIconsDir = filepath.Join(ctx.targetRoot, "icons")
+	ctx.targetBinDir = filepath.Join(newExportsInstanceDir, "bin")
 
-	targetDirsToCreate := []string{ctx.targetApplicationsDir, ctx.targetIconsDir}
+	targetDirsToCreate := []string{ctx.targetApplicationsDir, ctx.targetIconsDir, ctx.targetBinDir}
 	for _, dir := range targetDirsToCreate {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return errors.Wrapf(err, "failed to create %s", dir)
@@ -320,6 +326,7 @@ func UpdateDesktopFiles(ct *container.Container) error {
 	}
 
 	ctx.exportIcons()
+	ctx.exportBinaries()
 
 	tempExportsLink := ct.ExportsLink(true)
 	if err := os.Symlink(newExportsInstanceDir, tempExportsLink); err != nil {
@@ -338,3 +345,24 @@ func UpdateDesktopFiles(ct *container.Container) error {
 
 	return nil
 }
+
+// Returns the names of the desktop files currently exported from the container.
+func ExportedDesktopFiles(ct *container.Container) ([]string, error) {
+	applicationsDir := filepath.Join(ct.ExportsLink(false), "share", "applications")
+
+	entries, err := ioutil.ReadDir(applicationsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read exported desktop files")
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
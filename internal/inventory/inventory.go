@@ -15,8 +15,37 @@ import (
 	"github.com/refi64/nsbox/internal/log"
 	"github.com/refi64/nsbox/internal/paths"
 	"github.com/refi64/nsbox/internal/userdata"
+	"golang.org/x/sys/unix"
 )
 
+// Creates the user's storage directory if needed, and makes sure nobody outside of root and the
+// user's primary group can read it. It stays owned by root, so the user can't change anything in
+// it directly, but the group can read it so commands that only look at their containers don't
+// need root.
+func PrepareUserStorage(usrdata *userdata.Userdata) error {
+	path := paths.UserStorage(usrdata)
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return errors.Wrap(err, "failed to create user storage directory")
+	}
+
+	_, gid := usrdata.NumericIds()
+	if err := os.Chown(path, 0, gid); err != nil {
+		return errors.Wrap(err, "failed to change user storage directory owner")
+	}
+
+	if err := os.Chmod(path, 0750); err != nil {
+		return errors.Wrap(err, "failed to chmod user storage directory")
+	}
+
+	return nil
+}
+
+// Returns true if the current user can read the user's storage directory without root.
+func CanReadUserStorage(usrdata *userdata.Userdata) bool {
+	err := unix.Access(paths.UserStorage(usrdata), unix.R_OK|unix.X_OK)
+	return err == nil || os.IsNotExist(err)
+}
+
 func List(usrdata *userdata.Userdata) ([]*container.Container, error) {
 	containers := []*container.Container{}
 
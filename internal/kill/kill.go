@@ -5,9 +5,12 @@
 package kill
 
 import (
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	systemd1 "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/coreos/go-systemd/v22/machine1"
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/container"
@@ -23,42 +26,70 @@ import "C"
 
 type Signal unix.Signal
 
+const (
+	// How long to wait for a container to exit after it's been killed.
+	DefaultTimeout = 30 * time.Second
+
+	lockPollInterval = 100 * time.Millisecond
+)
+
 var (
 	// systemd sends SIGRTMIN + 4 to signify poweroff and SIGINT for reboot.
 	SigPoweroff = Signal(C.SIGRTMIN + 4)
 	SigKill     = Signal(unix.SIGKILL)
+	SigTerm     = Signal(unix.SIGTERM)
+
+	// Signals that are expected to make the container exit, and thus are worth waiting on.
+	terminatingSignals = map[Signal]interface{}{
+		SigPoweroff:          nil,
+		SigKill:              nil,
+		SigTerm:              nil,
+		Signal(unix.SIGINT):  nil,
+		Signal(unix.SIGQUIT): nil,
+	}
+)
 
-	sigToString = map[Signal]string{
-		SigPoweroff: "poweroff",
-		SigKill:     "sigkill",
+func (sig Signal) String() string {
+	if sig == SigPoweroff {
+		return "poweroff"
 	}
 
-	stringToSig = map[string]Signal{
-		"poweroff": SigPoweroff,
-		"kill":     SigKill,
-		"sigkill":  SigKill,
+	if name := unix.SignalName(unix.Signal(sig)); name != "" {
+		return strings.ToLower(name)
 	}
-)
 
-func (sig Signal) String() string {
-	return sigToString[sig]
+	return fmt.Sprint(int(sig))
 }
 
+// Accepts "poweroff", or any signal name with or without the SIG prefix.
 func (sig *Signal) Set(value string) error {
-	newSig, ok := stringToSig[strings.ToLower(value)]
-	if !ok {
+	value = strings.ToUpper(value)
+	if value == "POWEROFF" {
+		*sig = SigPoweroff
+		return nil
+	}
+
+	if !strings.HasPrefix(value, "SIG") {
+		value = "SIG" + value
+	}
+
+	newSig := unix.SignalNum(value)
+	if newSig == 0 {
 		return errors.New("does not exist")
 	}
 
-	*sig = newSig
+	*sig = Signal(newSig)
 	return nil
 }
 
-func KillContainer(usrdata *userdata.Userdata, ct *container.Container, signal Signal, all bool) error {
-	if ct.Config.Boot && all {
-		return errors.New("-a/--all is not supported for booted containers")
-	}
+func (sig Signal) terminates() bool {
+	_, ok := terminatingSignals[sig]
+	return ok
+}
 
+// Sends the signal to the container. If all is true, every process inside will receive it,
+// otherwise only the leader will.
+func SignalContainer(usrdata *userdata.Userdata, ct *container.Container, signal Signal, all bool) error {
 	machined, err := machine1.New()
 	if err != nil {
 		return err
@@ -96,11 +127,100 @@ func KillContainer(usrdata *userdata.Userdata, ct *container.Container, signal S
 		}
 	}
 
-	lock, err := ct.Lock(container.RunLock, container.WaitForLock)
+	return nil
+}
+
+// Waits for the container to exit, returning false if it's still running after the timeout.
+func WaitForContainer(ct *container.Container, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		lock, err := ct.Lock(container.RunLock, container.NoWaitForLock)
+		if err == nil {
+			lock.Release()
+			return true, nil
+		} else if err != container.ErrLocked {
+			return false, err
+		}
+
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Sends the signal to the container, then, if it's one that should make the container exit,
+// waits up to the timeout for it to do so.
+func KillContainer(usrdata *userdata.Userdata, ct *container.Container, signal Signal, all bool, timeout time.Duration) error {
+	if err := SignalContainer(usrdata, ct, signal, all); err != nil {
+		return err
+	}
+
+	if !signal.terminates() {
+		return nil
+	}
+
+	exited, err := WaitForContainer(ct, timeout)
+	if err != nil {
+		return err
+	} else if !exited {
+		return errors.Errorf("container %s did not exit within %s", ct.Name, timeout)
+	}
+
+	return nil
+}
+
+// Gracefully stops the container, by powering it off if it's booted or sending SIGTERM to all
+// of its processes otherwise. If it's still running after the timeout, it's sent SIGKILL.
+func StopContainer(usrdata *userdata.Userdata, ct *container.Container, timeout time.Duration) error {
+	// A paused container can't react to being asked to stop.
+	if paused, err := ct.IsPaused(usrdata); err != nil {
+		return errors.Wrap(err, "checking if the container is paused")
+	} else if paused {
+		if err := ct.Resume(usrdata); err != nil {
+			return errors.Wrap(err, "resuming container")
+		}
+	}
+
+	var err error
+	if ct.Config.Boot {
+		err = SignalContainer(usrdata, ct, SigPoweroff, false)
+	} else {
+		err = SignalContainer(usrdata, ct, SigTerm, true)
+	}
+
 	if err != nil {
 		return err
 	}
 
-	lock.Release()
+	exited, err := WaitForContainer(ct, timeout)
+	if err != nil {
+		return err
+	} else if exited {
+		return nil
+	}
+
+	log.Alertf("Container %s did not stop within %s, killing it", ct.Name, timeout)
+	if err := KillContainer(usrdata, ct, SigKill, true, timeout); err != nil {
+		return err
+	}
+
+	if ct.Config.Restart != container.RestartNo {
+		// Being killed counts as a failure, so make sure the restart policy doesn't bring the
+		// container right back up.
+		systemd, err := systemd1.NewSystemConnection()
+		if err != nil {
+			return err
+		}
+
+		defer systemd.Close()
+
+		if _, err := systemd.StopUnit(ct.ServiceName(usrdata), "replace", nil); err != nil {
+			return errors.Wrap(err, "cancelling container restart")
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,105 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package manager
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const introspectableInterface = "org.freedesktop.DBus.Introspectable"
+
+// Containers and images are referred to by object path. Methods that act on a container or
+// image will fail with .Error.NotFound if it doesn't exist, and all of them fail with
+// .Error.AccessDenied if polkit doesn't authorize the caller.
+
+var managerIntrospection = fmt.Sprintf(`<node>
+  <interface name="%s">
+    <method name="ListContainers">
+      <arg name="containers" type="ao" direction="out"/>
+    </method>
+    <method name="GetContainer">
+      <arg name="name" type="s" direction="in"/>
+      <arg name="container" type="o" direction="out"/>
+    </method>
+    <method name="GetDefaultContainer">
+      <!-- This is / if there is no default container. -->
+      <arg name="container" type="o" direction="out"/>
+    </method>
+    <method name="SetDefaultContainer">
+      <!-- An empty name unsets the default container. -->
+      <arg name="name" type="s" direction="in"/>
+    </method>
+    <method name="CreateContainer">
+      <!-- Returns once the creation has started. ContainerAdded or ContainerCreationFailed
+           is emitted with the returned path once it's done. -->
+      <arg name="image" type="s" direction="in"/>
+      <arg name="name" type="s" direction="in"/>
+      <arg name="boot" type="b" direction="in"/>
+      <arg name="container" type="o" direction="out"/>
+    </method>
+    <method name="ListImages">
+      <arg name="images" type="ao" direction="out"/>
+    </method>
+    <signal name="ContainerAdded">
+      <arg name="container" type="o"/>
+    </signal>
+    <signal name="ContainerCreationFailed">
+      <arg name="container" type="o"/>
+      <arg name="error" type="s"/>
+    </signal>
+    <signal name="ContainerRemoved">
+      <arg name="container" type="o"/>
+    </signal>
+    <signal name="ContainerStateChanged">
+      <arg name="container" type="o"/>
+      <!-- One of: stopped, running, or paused. -->
+      <arg name="state" type="s"/>
+    </signal>
+    <signal name="DefaultContainerChanged">
+      <arg name="container" type="o"/>
+    </signal>
+  </interface>
+  %s
+</node>`, managerInterface, introspect.IntrospectDataString)
+
+var containerIntrospection = fmt.Sprintf(`<node>
+  <interface name="%s">
+    <method name="GetInfo">
+      <!-- Name (s), Image (s), Boot (b), State (s: stopped, running, or paused), and
+           Default (b). -->
+      <arg name="info" type="a{sv}" direction="out"/>
+    </method>
+    <method name="GetConfig">
+      <!-- The container's config, as JSON. -->
+      <arg name="config" type="s" direction="out"/>
+    </method>
+    <method name="SetConfig">
+      <!-- Replaces the container's config. Image and Boot cannot be changed. -->
+      <arg name="config" type="s" direction="in"/>
+    </method>
+    <method name="Start">
+      <arg name="restart" type="b" direction="in"/>
+    </method>
+    <method name="Kill">
+      <!-- poweroff, or any signal name. -->
+      <arg name="signal" type="s" direction="in"/>
+      <arg name="all" type="b" direction="in"/>
+    </method>
+    <method name="Delete"/>
+  </interface>
+  %s
+</node>`, containerInterface, introspect.IntrospectDataString)
+
+var imageIntrospection = fmt.Sprintf(`<node>
+  <interface name="%s">
+    <method name="GetInfo">
+      <!-- Name (s), Base (s), Remote (s), Parent (s), and ValidTags (as). -->
+      <arg name="info" type="a{sv}" direction="out"/>
+    </method>
+  </interface>
+  %s
+</node>`, imageInterface, introspect.IntrospectDataString)
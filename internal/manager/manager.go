@@ -0,0 +1,480 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// The manager is a system bus service that exposes nsbox's containers and images to other
+// applications (e.g. graphical front-ends), so they don't have to go through the CLI.
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	systemd1 "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/config"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/create"
+	"github.com/refi64/nsbox/internal/image"
+	"github.com/refi64/nsbox/internal/inventory"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
+	"github.com/refi64/nsbox/internal/polkit"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+// These all follow the build's reverse-DNS name, so e.g. edge builds use dev.nsbox.edge.Manager.
+var (
+	BusName            = config.RdnsName + ".Manager"
+	managerInterface   = BusName
+	containerInterface = BusName + ".Container"
+	imageInterface     = BusName + ".Image"
+
+	failedError   = BusName + ".Error.Failed"
+	deniedError   = BusName + ".Error.AccessDenied"
+	notFoundError = BusName + ".Error.NotFound"
+
+	managerPath = dbus.ObjectPath("/" + strings.Replace(BusName, ".", "/", -1))
+	usersPath   = managerPath + "/user"
+	imagesPath  = managerPath + "/image"
+)
+
+// Returned in place of an object when there is none.
+const emptyPath = dbus.ObjectPath("/")
+
+const machinedInterface = "org.freedesktop.machine1.Manager"
+
+const (
+	stateStopped = "stopped"
+	stateRunning = "running"
+	statePaused  = "paused"
+)
+
+type Manager struct {
+	bus     *dbus.Conn
+	systemd *systemd1.Conn
+
+	// The containers that are still being created, by path.
+	creatingMutex sync.Mutex
+	creating      map[dbus.ObjectPath]bool
+}
+
+type managerObject struct {
+	manager *Manager
+}
+
+func newError(name string, err error) *dbus.Error {
+	return dbus.NewError(name, []interface{}{err.Error()})
+}
+
+func failed(err error) *dbus.Error {
+	return newError(failedError, err)
+}
+
+func containerPath(uid int, name string) dbus.ObjectPath {
+	return usersPath + dbus.ObjectPath(fmt.Sprintf("/%d/container/%s", uid, systemd1.PathBusEscape(name)))
+}
+
+func imagePath(name string) dbus.ObjectPath {
+	return imagesPath + "/" + dbus.ObjectPath(systemd1.PathBusEscape(name))
+}
+
+func (manager *Manager) callerUid(sender dbus.Sender) (int, error) {
+	var uid uint32
+	call := manager.bus.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender))
+	if err := call.Store(&uid); err != nil {
+		return 0, errors.Wrap(err, "failed to get caller uid")
+	}
+
+	return int(uid), nil
+}
+
+// Makes sure the caller is allowed to perform the action, returning the caller's userdata.
+func (manager *Manager) authorize(sender dbus.Sender, action string) (*userdata.Userdata, *dbus.Error) {
+	uid, err := manager.callerUid(sender)
+	if err != nil {
+		return nil, failed(err)
+	}
+
+	if err := polkit.CheckAuthorization(manager.bus, polkit.SystemBusNameSubject(string(sender)), action); err != nil {
+		if err == polkit.ErrNotAuthorized {
+			return nil, newError(deniedError, errors.Errorf("not authorized to %s", action))
+		}
+
+		return nil, failed(err)
+	}
+
+	usrdata, err := userdata.ForUid(uid)
+	if err != nil {
+		return nil, failed(errors.Wrap(err, "failed to get caller's user data"))
+	}
+
+	return usrdata, nil
+}
+
+func usrdataUid(usrdata *userdata.Userdata) int {
+	uid, _ := strconv.Atoi(usrdata.User.Uid)
+	return uid
+}
+
+func (manager *Manager) emit(name string, values ...interface{}) {
+	if err := manager.bus.Emit(managerPath, managerInterface+"."+name, values...); err != nil {
+		log.Alertf("failed to emit %s: %v", name, err)
+	}
+}
+
+func (obj managerObject) ListContainers(sender dbus.Sender) ([]dbus.ObjectPath, *dbus.Error) {
+	usrdata, dbusErr := obj.manager.authorize(sender, "list")
+	if dbusErr != nil {
+		return nil, dbusErr
+	}
+
+	containers, err := inventory.List(usrdata)
+	if err != nil {
+		return nil, failed(err)
+	}
+
+	result := []dbus.ObjectPath{}
+	for _, ct := range containers {
+		result = append(result, containerPath(usrdataUid(usrdata), ct.Name))
+	}
+
+	return result, nil
+}
+
+func (obj managerObject) GetContainer(sender dbus.Sender, name string) (dbus.ObjectPath, *dbus.Error) {
+	usrdata, dbusErr := obj.manager.authorize(sender, "list")
+	if dbusErr != nil {
+		return emptyPath, dbusErr
+	}
+
+	if _, err := container.Open(usrdata, name); err != nil {
+		return emptyPath, newError(notFoundError, err)
+	}
+
+	return containerPath(usrdataUid(usrdata), name), nil
+}
+
+func (obj managerObject) GetDefaultContainer(sender dbus.Sender) (dbus.ObjectPath, *dbus.Error) {
+	usrdata, dbusErr := obj.manager.authorize(sender, "list")
+	if dbusErr != nil {
+		return emptyPath, dbusErr
+	}
+
+	def, err := inventory.DefaultContainer(usrdata)
+	if err != nil {
+		return emptyPath, failed(err)
+	} else if def == nil {
+		return emptyPath, nil
+	}
+
+	return containerPath(usrdataUid(usrdata), def.Name), nil
+}
+
+func (obj managerObject) SetDefaultContainer(sender dbus.Sender, name string) *dbus.Error {
+	usrdata, dbusErr := obj.manager.authorize(sender, "set-default")
+	if dbusErr != nil {
+		return dbusErr
+	}
+
+	if err := inventory.SetDefaultContainer(usrdata, name); err != nil {
+		return failed(err)
+	}
+
+	path := emptyPath
+	if name != "" {
+		path = containerPath(usrdataUid(usrdata), name)
+	}
+
+	obj.manager.emit("DefaultContainerChanged", path)
+	return nil
+}
+
+// Marks the container as being created, returning false if it already was.
+func (manager *Manager) startCreating(path dbus.ObjectPath) bool {
+	manager.creatingMutex.Lock()
+	defer manager.creatingMutex.Unlock()
+
+	if manager.creating[path] {
+		return false
+	}
+
+	manager.creating[path] = true
+	return true
+}
+
+func (manager *Manager) finishCreating(path dbus.ObjectPath) {
+	manager.creatingMutex.Lock()
+	defer manager.creatingMutex.Unlock()
+
+	delete(manager.creating, path)
+}
+
+// Copying the image can take far longer than a D-Bus call may, so this only checks the
+// arguments and returns the new container's path right away. Once the container is ready,
+// ContainerAdded is emitted, or ContainerCreationFailed if it couldn't be created.
+func (obj managerObject) CreateContainer(sender dbus.Sender, imageName, name string,
+	boot bool) (dbus.ObjectPath, *dbus.Error) {
+	usrdata, dbusErr := obj.manager.authorize(sender, "create")
+	if dbusErr != nil {
+		return emptyPath, dbusErr
+	}
+
+	if err := container.ValidateName(name); err != nil {
+		return emptyPath, newError(dbus.ErrMsgInvalidArg.Name, err)
+	}
+
+	if _, err := image.Open(imageName, true); err != nil {
+		return emptyPath, newError(notFoundError, errors.Wrap(err, "failed to open image"))
+	}
+
+	if _, err := container.Open(usrdata, name); err == nil {
+		return emptyPath, failed(errors.Errorf("container %s already exists", name))
+	}
+
+	path := containerPath(usrdataUid(usrdata), name)
+	if !obj.manager.startCreating(path) {
+		return emptyPath, failed(errors.Errorf("container %s is already being created", name))
+	}
+
+	log.Infof("creating container %s for %s from %s", name, usrdata.User.Username, imageName)
+
+	go func() {
+		defer obj.manager.finishCreating(path)
+
+		config := container.Config{Image: imageName, Boot: boot}
+		if err := create.CreateContainer(usrdata, name, "", config); err != nil {
+			log.Alertf("failed to create container %s for %s: %v", name, usrdata.User.Username, err)
+			obj.manager.emit("ContainerCreationFailed", path, err.Error())
+			return
+		}
+
+		obj.manager.emit("ContainerAdded", path)
+	}()
+
+	return path, nil
+}
+
+func (obj managerObject) ListImages(sender dbus.Sender) ([]dbus.ObjectPath, *dbus.Error) {
+	if _, dbusErr := obj.manager.authorize(sender, "images"); dbusErr != nil {
+		return nil, dbusErr
+	}
+
+	images, err := image.List()
+	if err != nil {
+		return nil, failed(err)
+	}
+
+	result := []dbus.ObjectPath{}
+	for _, img := range images {
+		result = append(result, imagePath(imageName(img)))
+	}
+
+	return result, nil
+}
+
+// Finds the container that belongs to the machine, if any.
+func findMachineContainer(machineName string) (*userdata.Userdata, *container.Container) {
+	users, err := ioutil.ReadDir(paths.StorageRoot)
+	if err != nil {
+		log.Debug("failed to read storage root:", err)
+		return nil, nil
+	}
+
+	for _, entry := range users {
+		if !entry.IsDir() {
+			continue
+		}
+
+		usr, err := user.Lookup(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		uid, _ := strconv.Atoi(usr.Uid)
+		usrdata, err := userdata.ForUid(uid)
+		if err != nil {
+			log.Debugf("failed to get user data for %s: %v", usr.Username, err)
+			continue
+		}
+
+		prefix := usrdata.EscapedUsername() + "-"
+		if !strings.HasPrefix(machineName, prefix) {
+			continue
+		}
+
+		if ct, err := container.Open(usrdata, strings.TrimPrefix(machineName, prefix)); err == nil {
+			return usrdata, ct
+		}
+	}
+
+	return nil, nil
+}
+
+func (manager *Manager) emitStateChanged(machineName, state string) {
+	usrdata, ct := findMachineContainer(machineName)
+	if ct == nil {
+		return
+	}
+
+	manager.emit("ContainerStateChanged", containerPath(usrdataUid(usrdata), ct.Name), state)
+}
+
+// Turns machined's signals about machines starting and stopping into ContainerStateChanged.
+func (manager *Manager) watchMachines() error {
+	match := fmt.Sprintf("type='signal',interface='%s'", machinedInterface)
+	if err := manager.bus.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Store(); err != nil {
+		return errors.Wrap(err, "failed to watch machines")
+	}
+
+	signals := make(chan *dbus.Signal, 16)
+	manager.bus.Signal(signals)
+
+	go func() {
+		for signal := range signals {
+			var state string
+			switch signal.Name {
+			case machinedInterface + ".MachineNew":
+				state = stateRunning
+			case machinedInterface + ".MachineRemoved":
+				state = stateStopped
+			default:
+				continue
+			}
+
+			if len(signal.Body) < 1 {
+				continue
+			}
+
+			machineName, ok := signal.Body[0].(string)
+			if !ok {
+				continue
+			}
+
+			manager.emitStateChanged(machineName, state)
+		}
+	}()
+
+	return nil
+}
+
+// Pausing and resuming a container doesn't go through machined, so the container's unit is
+// watched for changes to its freezer state instead. (This only works on systemd versions that
+// can freeze units, with older ones falling back to writing to the cgroup behind its back.)
+func (manager *Manager) watchFreezerStates() error {
+	if err := manager.systemd.Subscribe(); err != nil {
+		return errors.Wrap(err, "failed to subscribe to systemd")
+	}
+
+	updates := make(chan *systemd1.PropertiesUpdate, 256)
+	manager.systemd.SetPropertiesSubscriber(updates, make(chan error, 1))
+
+	go func() {
+		for update := range updates {
+			if !strings.HasPrefix(update.UnitName, "nsbox-") ||
+				!strings.HasSuffix(update.UnitName, ".service") {
+				continue
+			}
+
+			freezerState, ok := update.Changed["FreezerState"]
+			if !ok {
+				continue
+			}
+
+			var state string
+			switch freezerState.Value() {
+			case "frozen":
+				state = statePaused
+			case "running":
+				state = stateRunning
+			default:
+				// Still freezing or thawing.
+				continue
+			}
+
+			machineName := strings.TrimSuffix(strings.TrimPrefix(update.UnitName, "nsbox-"), ".service")
+			manager.emitStateChanged(machineName, state)
+		}
+	}()
+
+	return nil
+}
+
+func (manager *Manager) export() error {
+	exports := []struct {
+		value   interface{}
+		path    dbus.ObjectPath
+		iface   string
+		subtree bool
+	}{
+		{managerObject{manager}, managerPath, managerInterface, false},
+		{introspect.Introspectable(managerIntrospection), managerPath, introspectableInterface, false},
+		{containerObject{manager}, usersPath, containerInterface, true},
+		{introspect.Introspectable(containerIntrospection), usersPath, introspectableInterface, true},
+		{imageObject{manager}, imagesPath, imageInterface, true},
+		{introspect.Introspectable(imageIntrospection), imagesPath, introspectableInterface, true},
+	}
+
+	for _, export := range exports {
+		var err error
+		if export.subtree {
+			err = manager.bus.ExportSubtree(export.value, export.path, export.iface)
+		} else {
+			err = manager.bus.Export(export.value, export.path, export.iface)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "failed to export %s on %s", export.iface, export.path)
+		}
+	}
+
+	return nil
+}
+
+// Starts serving the manager on the system bus. This returns once the bus name is acquired,
+// with the service running in the background.
+func Start() (*Manager, error) {
+	if err := os.MkdirAll(paths.StorageRoot, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create state directory")
+	}
+
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to system bus")
+	}
+
+	systemd, err := systemd1.NewSystemConnection()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to systemd")
+	}
+
+	manager := &Manager{bus: bus, systemd: systemd, creating: map[dbus.ObjectPath]bool{}}
+
+	if err := manager.export(); err != nil {
+		return nil, err
+	}
+
+	if err := manager.watchMachines(); err != nil {
+		return nil, err
+	}
+
+	if err := manager.watchFreezerStates(); err != nil {
+		return nil, err
+	}
+
+	reply, err := bus.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request %s", BusName)
+	} else if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, errors.Errorf("%s is already taken", BusName)
+	}
+
+	return manager, nil
+}
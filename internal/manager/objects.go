@@ -0,0 +1,280 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package manager
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	systemd1 "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/daemon"
+	"github.com/refi64/nsbox/internal/image"
+	"github.com/refi64/nsbox/internal/integration"
+	"github.com/refi64/nsbox/internal/inventory"
+	"github.com/refi64/nsbox/internal/kill"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/network"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+// Every container of every user is exported under the same subtree, at
+// /dev/nsbox/Manager/user/UID/container/NAME.
+type containerObject struct {
+	manager *Manager
+}
+
+// Images are exported under /dev/nsbox/Manager/image/NAME.
+type imageObject struct {
+	manager *Manager
+}
+
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return path
+}
+
+// Opens the container the message was sent to, after checking the caller is authorized to
+// perform the action on it. Callers may only access their own containers.
+func (obj containerObject) open(msg dbus.Message, sender dbus.Sender,
+	action string) (*userdata.Userdata, *container.Container, *dbus.Error) {
+	path := messagePath(msg)
+	parts := strings.Split(strings.TrimPrefix(string(path), string(usersPath)+"/"), "/")
+	if len(parts) != 3 || parts[1] != "container" {
+		return nil, nil, newError(notFoundError, errors.Errorf("invalid container path: %s", path))
+	}
+
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, nil, newError(notFoundError, errors.Errorf("invalid container path: %s", path))
+	}
+
+	usrdata, dbusErr := obj.manager.authorize(sender, action)
+	if dbusErr != nil {
+		return nil, nil, dbusErr
+	}
+
+	if usrdataUid(usrdata) != uid {
+		return nil, nil, newError(deniedError, errors.New("cannot access another user's container"))
+	}
+
+	// Container names are never unescaped, instead the escaped name is compared against
+	// every container's.
+	containers, err := inventory.List(usrdata)
+	if err != nil {
+		return nil, nil, failed(err)
+	}
+
+	for _, ct := range containers {
+		if systemd1.PathBusEscape(ct.Name) == parts[2] {
+			return usrdata, ct, nil
+		}
+	}
+
+	return nil, nil, newError(notFoundError, errors.Errorf("container does not exist: %s", path))
+}
+
+func containerState(usrdata *userdata.Userdata, ct *container.Container) string {
+	if _, err := ct.Leader(usrdata); err != nil {
+		return stateStopped
+	}
+
+	if paused, err := ct.IsPaused(usrdata); err != nil {
+		log.Debugf("failed to get paused state of %s: %v", ct.Name, err)
+	} else if paused {
+		return statePaused
+	}
+
+	return stateRunning
+}
+
+func (obj containerObject) GetInfo(msg dbus.Message, sender dbus.Sender) (map[string]dbus.Variant, *dbus.Error) {
+	usrdata, ct, dbusErr := obj.open(msg, sender, "info")
+	if dbusErr != nil {
+		return nil, dbusErr
+	}
+
+	isDefault := false
+	if def, err := inventory.DefaultContainer(usrdata); err != nil {
+		log.Debug("failed to get default container:", err)
+	} else if def != nil {
+		isDefault = def.Name == ct.Name
+	}
+
+	return map[string]dbus.Variant{
+		"Name":    dbus.MakeVariant(ct.Name),
+		"Image":   dbus.MakeVariant(ct.Config.Image),
+		"Boot":    dbus.MakeVariant(ct.Config.Boot),
+		"State":   dbus.MakeVariant(containerState(usrdata, ct)),
+		"Default": dbus.MakeVariant(isDefault),
+	}, nil
+}
+
+func (obj containerObject) GetConfig(msg dbus.Message, sender dbus.Sender) (string, *dbus.Error) {
+	_, ct, dbusErr := obj.open(msg, sender, "info")
+	if dbusErr != nil {
+		return "", dbusErr
+	}
+
+	data, err := json.Marshal(ct.Config)
+	if err != nil {
+		return "", failed(errors.Wrap(err, "failed to marshal config"))
+	}
+
+	return string(data), nil
+}
+
+// Replaces the container's config with the given JSON, which is in the same format as the
+// container's config.json. Like 'nsbox config', the image and boot settings can't be changed.
+func (obj containerObject) SetConfig(msg dbus.Message, sender dbus.Sender, configJson string) *dbus.Error {
+	usrdata, ct, dbusErr := obj.open(msg, sender, "config")
+	if dbusErr != nil {
+		return dbusErr
+	}
+
+	lock, err := ct.Lock(container.ConfigLock, container.NoWaitForLock)
+	if err != nil {
+		return failed(err)
+	}
+
+	defer lock.Release()
+
+	var config container.Config
+	if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+		return newError(dbus.ErrMsgInvalidArg.Name, errors.Wrap(err, "invalid config"))
+	}
+
+	config.Image = ct.Config.Image
+	config.Boot = ct.Config.Boot
+	ct.Config = &config
+
+	if config.SharedNetwork != "" {
+		if _, err := network.OpenShared(usrdata, config.SharedNetwork); err != nil {
+			return failed(err)
+		}
+	}
+
+	if err := ct.UpdateConfig(); err != nil {
+		return failed(err)
+	}
+
+	if err := daemon.UpdateEnabledContainer(ct, usrdata); err != nil {
+		return failed(err)
+	}
+
+	if err := integration.UpdateDesktopFiles(ct); err != nil {
+		return failed(err)
+	}
+
+	return nil
+}
+
+func (obj containerObject) Start(msg dbus.Message, sender dbus.Sender, restart bool) *dbus.Error {
+	usrdata, ct, dbusErr := obj.open(msg, sender, "start")
+	if dbusErr != nil {
+		return dbusErr
+	}
+
+	if err := daemon.RunContainerViaTransientUnit(ct, restart, usrdata); err != nil {
+		return failed(err)
+	}
+
+	return nil
+}
+
+// Sends the signal (in the same format as 'nsbox kill -signal') to the container, waiting for it
+// to exit if the signal should make it do so.
+func (obj containerObject) Kill(msg dbus.Message, sender dbus.Sender, signalName string, all bool) *dbus.Error {
+	usrdata, ct, dbusErr := obj.open(msg, sender, "kill")
+	if dbusErr != nil {
+		return dbusErr
+	}
+
+	var signal kill.Signal
+	if err := signal.Set(signalName); err != nil {
+		return newError(dbus.ErrMsgInvalidArg.Name, errors.Wrapf(err, "invalid signal %s", signalName))
+	}
+
+	if err := kill.KillContainer(usrdata, ct, signal, all, kill.DefaultTimeout); err != nil {
+		return failed(err)
+	}
+
+	return nil
+}
+
+func (obj containerObject) Delete(msg dbus.Message, sender dbus.Sender) *dbus.Error {
+	usrdata, ct, dbusErr := obj.open(msg, sender, "delete")
+	if dbusErr != nil {
+		return dbusErr
+	}
+
+	def, err := inventory.DefaultContainer(usrdata)
+	if err != nil {
+		return failed(err)
+	}
+
+	if def != nil && def.Name == ct.Name {
+		return failed(errors.New("cannot delete the default container"))
+	}
+
+	enabled, err := daemon.IsContainerEnabled(ct, usrdata)
+	if err != nil {
+		return failed(err)
+	}
+
+	if enabled {
+		if err := daemon.DisableContainer(ct, usrdata); err != nil {
+			return failed(err)
+		}
+	}
+
+	if err := ct.LockAndDelete(container.NoWaitForLock); err != nil {
+		return failed(err)
+	}
+
+	obj.manager.emit("ContainerRemoved", messagePath(msg))
+	return nil
+}
+
+func imageName(img *image.Image) string {
+	return filepath.Base(img.RootPath)
+}
+
+func (obj imageObject) GetInfo(msg dbus.Message, sender dbus.Sender) (map[string]dbus.Variant, *dbus.Error) {
+	if _, dbusErr := obj.manager.authorize(sender, "images"); dbusErr != nil {
+		return nil, dbusErr
+	}
+
+	images, err := image.List()
+	if err != nil {
+		return nil, failed(err)
+	}
+
+	path := messagePath(msg)
+	for _, img := range images {
+		if imagePath(imageName(img)) != path {
+			continue
+		}
+
+		validTags := img.ValidTags
+		if validTags == nil {
+			validTags = []string{}
+		}
+
+		return map[string]dbus.Variant{
+			"Name":      dbus.MakeVariant(imageName(img)),
+			"Base":      dbus.MakeVariant(img.Base),
+			"Remote":    dbus.MakeVariant(img.Remote),
+			"Parent":    dbus.MakeVariant(img.Parent),
+			"ValidTags": dbus.MakeVariant(validTags),
+		}, nil
+	}
+
+	return nil, newError(notFoundError, errors.Errorf("image does not exist: %s", path))
+}
@@ -0,0 +1,170 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package network
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A single destination that a container is allowed to reach.
+type EgressRule struct {
+	// The destination network, or nil to allow any destination.
+	Net *net.IPNet
+	// The destination TCP/UDP port, or 0 to allow any port.
+	Port uint16
+}
+
+// Restricts the traffic a container may send out through the host.
+type EgressPolicy struct {
+	// If false, all traffic is allowed and Rules is ignored. Otherwise, only traffic matching
+	// one of the Rules is allowed.
+	Restrict bool
+	Rules    []EgressRule
+}
+
+var AllowAllEgress = EgressPolicy{}
+
+// An unresolved allow-list entry, in the form HOST[:PORT], where HOST may be an IP address,
+// a CIDR, a hostname, or empty to allow any destination. IPv6 addresses must be wrapped in
+// brackets if a port is given.
+type EgressTarget struct {
+	Host string
+	Net  *net.IPNet
+	Port uint16
+}
+
+func ParseEgressTarget(spec string) (*EgressTarget, error) {
+	host := spec
+	var port uint16
+
+	// Only try to split off a port if it's unambiguous, i.e. there's only one colon (IPv4 or
+	// hostname) or the host is wrapped in brackets (IPv6).
+	if strings.Count(spec, ":") == 1 || strings.HasPrefix(spec, "[") {
+		var portStr string
+		var err error
+
+		host, portStr, err = net.SplitHostPort(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid egress target %s", spec)
+		}
+
+		parsed, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || parsed == 0 {
+			return nil, errors.Errorf("invalid egress target port: %s", spec)
+		}
+
+		port = uint16(parsed)
+	}
+
+	target := &EgressTarget{Port: port}
+
+	if host == "" {
+		if port == 0 {
+			return nil, errors.Errorf("egress target must have a host or port: %s", spec)
+		}
+	} else if _, ipnet, err := net.ParseCIDR(host); err == nil {
+		target.Net = ipnet
+	} else if ip := net.ParseIP(host); ip != nil {
+		target.Net = singleIPNet(ip)
+	} else if isValidHostname(host) {
+		target.Host = host
+	} else {
+		return nil, errors.Errorf("invalid egress target host: %s", spec)
+	}
+
+	return target, nil
+}
+
+func isValidHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func singleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// Converts the target into firewall rules. Hostnames are resolved on the host, so the
+// resulting rules will only contain the addresses the host currently sees.
+func (target EgressTarget) Resolve() ([]EgressRule, error) {
+	if target.Host == "" {
+		return []EgressRule{{Net: target.Net, Port: target.Port}}, nil
+	}
+
+	ips, err := net.LookupIP(target.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving egress host %s", target.Host)
+	}
+
+	rules := []EgressRule{}
+	for _, ip := range ips {
+		rules = append(rules, EgressRule{Net: singleIPNet(ip), Port: target.Port})
+	}
+
+	return rules, nil
+}
+
+// Builds a restricted egress policy allowing only the given allow-list entries.
+func NewRestrictedEgressPolicy(allow []string) (EgressPolicy, error) {
+	policy := EgressPolicy{Restrict: true}
+
+	for _, spec := range allow {
+		target, err := ParseEgressTarget(spec)
+		if err != nil {
+			return policy, err
+		}
+
+		rules, err := target.Resolve()
+		if err != nil {
+			return policy, err
+		}
+
+		policy.Rules = append(policy.Rules, rules...)
+	}
+
+	return policy, nil
+}
+
+// Services on the host that a container can always reach, even with a restricted egress policy,
+// since its network can't be set up without them.
+var hostServiceRules = []EgressRule{
+	// DNS
+	{Port: 53},
+	// DHCP
+	{Port: 67},
+	// DHCPv6
+	{Port: 547},
+}
+
+// Returns the rules for traffic from the container to the host itself. With a restricted policy,
+// the container may only reach the host services it needs for networking, along with anything
+// the policy would also allow it to reach elsewhere; otherwise, a local proxy on the host could
+// be used to get around the policy.
+func (egress EgressPolicy) InputRules() []EgressRule {
+	return append(append([]EgressRule{}, hostServiceRules...), egress.Rules...)
+}
@@ -0,0 +1,264 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables/expr"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("invalid test CIDR %s: %v", cidr, err)
+	}
+
+	return ipnet
+}
+
+func TestParseEgressTarget(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		host string
+		net  string
+		port uint16
+		err  string
+	}{
+		// CIDRs
+		{spec: "10.0.0.0/8", net: "10.0.0.0/8"},
+		{spec: "10.1.2.3/8", net: "10.0.0.0/8"},
+		{spec: "fd00::/8", net: "fd00::/8"},
+		{spec: "10.0.0.0/8:443", net: "10.0.0.0/8", port: 443},
+		{spec: "[fd00::/8]:443", net: "fd00::/8", port: 443},
+
+		// Bare IPs
+		{spec: "192.168.1.1", net: "192.168.1.1/32"},
+		{spec: "::1", net: "::1/128"},
+		{spec: "fe80::1:2", net: "fe80::1:2/128"},
+
+		// Bare hosts
+		{spec: "example.com", host: "example.com"},
+		{spec: "my-host", host: "my-host"},
+
+		// host:port
+		{spec: "example.com:443", host: "example.com", port: 443},
+		{spec: "192.168.1.1:22", net: "192.168.1.1/32", port: 22},
+		{spec: "[::1]:8080", net: "::1/128", port: 8080},
+		{spec: ":53", port: 53},
+
+		// Invalid input
+		{spec: "", err: "must have a host or port"},
+		{spec: ":", err: "invalid egress target port"},
+		{spec: ":0", err: "invalid egress target port"},
+		{spec: "example.com:65536", err: "invalid egress target port"},
+		{spec: "example.com:http", err: "invalid egress target port"},
+		{spec: "[::1]", err: "invalid egress target"},
+		{spec: "-example.com", err: "invalid egress target host"},
+		{spec: "exa_mple.com", err: "invalid egress target host"},
+		{spec: "example..com", err: "invalid egress target host"},
+		{spec: "10.0.0.0/33", err: "invalid egress target host"},
+		{spec: strings.Repeat("a", 64) + ".com", err: "invalid egress target host"},
+	} {
+		target, err := ParseEgressTarget(test.spec)
+
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.spec, target)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error containing %q, got %q", test.spec, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.spec, err)
+			continue
+		}
+
+		if target.Host != test.host {
+			t.Errorf("%q: expected host %q, got %q", test.spec, test.host, target.Host)
+		}
+
+		if test.net == "" {
+			if target.Net != nil {
+				t.Errorf("%q: expected no network, got %s", test.spec, target.Net)
+			}
+		} else if target.Net == nil || target.Net.String() != test.net {
+			t.Errorf("%q: expected network %s, got %v", test.spec, test.net, target.Net)
+		}
+
+		if target.Port != test.port {
+			t.Errorf("%q: expected port %d, got %d", test.spec, test.port, target.Port)
+		}
+	}
+}
+
+func TestEgressTargetResolveLiteral(t *testing.T) {
+	target, err := ParseEgressTarget("10.0.0.0/8:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := target.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []EgressRule{{Net: mustParseCIDR(t, "10.0.0.0/8"), Port: 443}}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+}
+
+func TestEgressInputRules(t *testing.T) {
+	allowed := EgressRule{Net: mustParseCIDR(t, "10.0.0.0/8"), Port: 443}
+	egress := EgressPolicy{Restrict: true, Rules: []EgressRule{allowed}}
+
+	rules := egress.InputRules()
+	expected := append(append([]EgressRule{}, hostServiceRules...), allowed)
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+
+	// The host services must not leak into the policy itself.
+	if len(egress.Rules) != 1 {
+		t.Errorf("InputRules modified the policy's rules: %+v", egress.Rules)
+	}
+}
+
+func TestFirewalldEgressRules(t *testing.T) {
+	egress := EgressPolicy{Restrict: true, Rules: []EgressRule{
+		{Net: mustParseCIDR(t, "10.0.0.0/8")},
+		{Net: mustParseCIDR(t, "fd00::/8"), Port: 443},
+	}}
+
+	var got []string
+	for _, rule := range firewalldEgressRules("vz-test", egress) {
+		got = append(got, strings.Join(append([]string{rule.Ipv, rule.Chain,
+			fmt.Sprint(rule.Priority)}, rule.Args...), " "))
+	}
+
+	expected := []string{
+		"ipv4 FORWARD 0 -i vz-test -d 10.0.0.0/8 -j ACCEPT",
+		"ipv4 FORWARD 1 -i vz-test -j REJECT",
+		"ipv4 INPUT 0 -i vz-test -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p tcp --dport 53 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p udp --dport 53 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p tcp --dport 67 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p udp --dport 67 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p tcp --dport 547 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -p udp --dport 547 -j ACCEPT",
+		"ipv4 INPUT 0 -i vz-test -d 10.0.0.0/8 -j ACCEPT",
+		"ipv4 INPUT 1 -i vz-test -j REJECT",
+		"ipv6 FORWARD 0 -i vz-test -d fd00::/8 -p tcp --dport 443 -j ACCEPT",
+		"ipv6 FORWARD 0 -i vz-test -d fd00::/8 -p udp --dport 443 -j ACCEPT",
+		"ipv6 FORWARD 1 -i vz-test -j REJECT",
+		"ipv6 INPUT 0 -i vz-test -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p ipv6-icmp -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p tcp --dport 53 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p udp --dport 53 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p tcp --dport 67 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p udp --dport 67 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p tcp --dport 547 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -p udp --dport 547 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -d fd00::/8 -p tcp --dport 443 -j ACCEPT",
+		"ipv6 INPUT 0 -i vz-test -d fd00::/8 -p udp --dport 443 -j ACCEPT",
+		"ipv6 INPUT 1 -i vz-test -j REJECT",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected rules:\n%s\nexpected:\n%s", strings.Join(got, "\n"),
+			strings.Join(expected, "\n"))
+	}
+
+	for _, rule := range firewalldEgressRules("vz-test", egress) {
+		if rule.iface() != "vz-test" {
+			t.Errorf("rule %v would not be found again for removal", rule.Args)
+		}
+	}
+}
+
+func TestNftablesInterfaceRules(t *testing.T) {
+	countChains := func(rules []nftablesRule) map[nftablesChain]int {
+		counts := map[nftablesChain]int{}
+		for _, rule := range rules {
+			counts[rule.chain]++
+		}
+
+		return counts
+	}
+
+	for _, test := range []struct {
+		name   string
+		egress EgressPolicy
+		counts map[nftablesChain]int
+	}{
+		{
+			name:   "allow-all",
+			egress: AllowAllEgress,
+			// Accept in, accept out, accept back, masquerade.
+			counts: map[nftablesChain]int{nftablesInput: 1, nftablesForward: 2, nftablesPostrouting: 1},
+		},
+		{
+			name:   "deny-all",
+			egress: EgressPolicy{Restrict: true},
+			// Established, ICMPv6, 3 host services * 2 protocols, reject.
+			counts: map[nftablesChain]int{nftablesInput: 9, nftablesForward: 2, nftablesPostrouting: 1},
+		},
+		{
+			name: "allow-list",
+			egress: EgressPolicy{Restrict: true, Rules: []EgressRule{
+				{Net: mustParseCIDR(t, "10.0.0.0/8")},
+				{Port: 443},
+			}},
+			counts: map[nftablesChain]int{nftablesInput: 12, nftablesForward: 5, nftablesPostrouting: 1},
+		},
+	} {
+		rules := nftablesInterfaceRules("vz-test", test.egress)
+		if counts := countChains(rules); !reflect.DeepEqual(counts, test.counts) {
+			t.Errorf("%s: expected rules per chain %v, got %v", test.name, test.counts, counts)
+		}
+
+		if !test.egress.Restrict {
+			continue
+		}
+
+		// The last input and forward rules for traffic from the container must reject
+		// everything else.
+		for _, chain := range []nftablesChain{nftablesInput, nftablesForward} {
+			var last *nftablesRule
+			for i := range rules {
+				if rules[i].chain == chain && isFromIfaceRule(rules[i]) {
+					last = &rules[i]
+				}
+			}
+
+			if last == nil || !hasReject(*last) {
+				t.Errorf("%s: last rule in chain %d does not reject", test.name, chain)
+			}
+		}
+	}
+}
+
+func isFromIfaceRule(rule nftablesRule) bool {
+	meta, ok := rule.exprs[0].(*expr.Meta)
+	return ok && meta.Key == expr.MetaKeyIIFNAME
+}
+
+func hasReject(rule nftablesRule) bool {
+	for _, part := range rule.exprs {
+		if _, ok := part.(*expr.Reject); ok {
+			return true
+		}
+	}
+
+	return false
+}
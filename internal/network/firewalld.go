@@ -5,13 +5,18 @@
 package network
 
 import (
+	"fmt"
+
 	godbus "github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/config"
 	"github.com/refi64/nsbox/internal/log"
 )
 
 type firewalld struct {
 	systemBus *godbus.Conn
 	firewalld godbus.BusObject
+	zone      string
 }
 
 const (
@@ -20,16 +25,39 @@ const (
 
 	firewalldManagerIface = "org.fedoraproject.FirewallD1"
 	firewalldZoneIface    = "org.fedoraproject.FirewallD1.zone"
+	firewalldDirectIface  = "org.fedoraproject.FirewallD1.direct"
 
 	firewalldManagerVersionProp        = firewalldManagerIface + ".version"
+	firewalldZoneGetZonesMethod        = firewalldZoneIface + ".getZones"
 	firewalldZoneChangeInterfaceMethod = firewalldZoneIface + ".changeZoneOfInterface"
 	firewalldZoneRemoveInterfaceMethod = firewalldZoneIface + ".removeInterface"
+	firewalldZoneGetInterfacesMethod   = firewalldZoneIface + ".getInterfaces"
+	firewalldDirectAddRuleMethod       = firewalldDirectIface + ".addRule"
+	firewalldDirectRemoveRuleMethod    = firewalldDirectIface + ".removeRule"
+	firewalldDirectGetAllRulesMethod   = firewalldDirectIface + ".getAllRules"
 
 	defaultCallFlags = godbus.FlagNoAutoStart
 
+	// The zone installed alongside nsbox, which behaves like the trusted zone but is
+	// recognizably nsbox's.
+	nsboxZone = config.ProductName
+	// Fallback zone if firewalld hasn't been reloaded since nsbox's zone was installed.
 	trustedZone = "trusted"
+
+	// Egress rules are added to the forward and input chains as direct rules, with the allowed
+	// destinations having a higher priority than the final reject.
+	egressAllowPriority  = 0
+	egressRejectPriority = 1
 )
 
+type firewalldDirectRule struct {
+	Ipv      string
+	Table    string
+	Chain    string
+	Priority int32
+	Args     []string
+}
+
 func newFirewalld() *firewalld {
 	systemBus, err := godbus.SystemBus()
 	if err != nil {
@@ -44,21 +72,226 @@ func newFirewalld() *firewalld {
 	}
 
 	// firewalld is now confirmed present.
-	return &firewalld{systemBus: systemBus, firewalld: object}
+	fw := &firewalld{systemBus: systemBus, firewalld: object, zone: trustedZone}
+
+	var zones []string
+	if err := object.Call(firewalldZoneGetZonesMethod, defaultCallFlags).Store(&zones); err != nil {
+		log.Debug("Failed to get firewalld zones:", err)
+	}
+
+	for _, zone := range zones {
+		if zone == nsboxZone {
+			fw.zone = nsboxZone
+			break
+		}
+	}
+
+	if fw.zone != nsboxZone {
+		log.Alertf("NOTE: firewalld zone %s is missing, falling back to %s.", nsboxZone, trustedZone)
+		log.Alert("Run 'firewall-cmd --reload' to load it.")
+	}
+
+	return fw
 }
 
-func (fw *firewalld) TrustInterface(iface string) error {
-	call := fw.firewalld.Call(firewalldZoneChangeInterfaceMethod, defaultCallFlags,
-		trustedZone, iface)
+// Builds rules accepting traffic from the interface that matches any of the given rules.
+func firewalldAllowRules(ipv, chain, iface string, egressRules []EgressRule) []firewalldDirectRule {
+	rules := []firewalldDirectRule{}
+
+	for _, egressRule := range egressRules {
+		args := []string{"-i", iface}
+
+		if egressRule.Net != nil {
+			isIpv4 := egressRule.Net.IP.To4() != nil
+			if isIpv4 != (ipv == "ipv4") {
+				continue
+			}
+
+			args = append(args, "-d", egressRule.Net.String())
+		}
+
+		if egressRule.Port == 0 {
+			rules = append(rules, firewalldDirectRule{
+				Ipv:      ipv,
+				Table:    "filter",
+				Chain:    chain,
+				Priority: egressAllowPriority,
+				Args:     append(args, "-j", "ACCEPT"),
+			})
+		} else {
+			for _, proto := range []string{"tcp", "udp"} {
+				protoArgs := append(append([]string{}, args...), "-p", proto,
+					"--dport", fmt.Sprint(egressRule.Port), "-j", "ACCEPT")
+				rules = append(rules, firewalldDirectRule{
+					Ipv:      ipv,
+					Table:    "filter",
+					Chain:    chain,
+					Priority: egressAllowPriority,
+					Args:     protoArgs,
+				})
+			}
+		}
+	}
+
+	return rules
+}
+
+// Builds the direct rules enforcing a restricted egress policy, both for traffic forwarded
+// out of the container and for traffic to the host itself (which the zone would otherwise
+// accept in full).
+func firewalldEgressRules(iface string, egress EgressPolicy) []firewalldDirectRule {
+	rules := []firewalldDirectRule{}
+
+	for _, ipv := range []string{"ipv4", "ipv6"} {
+		rules = append(rules, firewalldAllowRules(ipv, "FORWARD", iface, egress.Rules)...)
+		rules = append(rules, firewalldDirectRule{
+			Ipv:      ipv,
+			Table:    "filter",
+			Chain:    "FORWARD",
+			Priority: egressRejectPriority,
+			Args:     []string{"-i", iface, "-j", "REJECT"},
+		})
+
+		// Replies to connections the host made into the container.
+		rules = append(rules, firewalldDirectRule{
+			Ipv:      ipv,
+			Table:    "filter",
+			Chain:    "INPUT",
+			Priority: egressAllowPriority,
+			Args: []string{"-i", iface, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED",
+				"-j", "ACCEPT"},
+		})
+
+		if ipv == "ipv6" {
+			// IPv6 can't even find its neighbors without ICMPv6.
+			rules = append(rules, firewalldDirectRule{
+				Ipv:      ipv,
+				Table:    "filter",
+				Chain:    "INPUT",
+				Priority: egressAllowPriority,
+				Args:     []string{"-i", iface, "-p", "ipv6-icmp", "-j", "ACCEPT"},
+			})
+		}
+
+		rules = append(rules, firewalldAllowRules(ipv, "INPUT", iface, egress.InputRules())...)
+		rules = append(rules, firewalldDirectRule{
+			Ipv:      ipv,
+			Table:    "filter",
+			Chain:    "INPUT",
+			Priority: egressRejectPriority,
+			Args:     []string{"-i", iface, "-j", "REJECT"},
+		})
+	}
+
+	return rules
+}
+
+func (fw *firewalld) addDirectRule(rule firewalldDirectRule) error {
+	call := fw.firewalld.Call(firewalldDirectAddRuleMethod, defaultCallFlags,
+		rule.Ipv, rule.Table, rule.Chain, rule.Priority, rule.Args)
+	return call.Err
+}
+
+func (fw *firewalld) removeDirectRule(rule firewalldDirectRule) error {
+	call := fw.firewalld.Call(firewalldDirectRemoveRuleMethod, defaultCallFlags,
+		rule.Ipv, rule.Table, rule.Chain, rule.Priority, rule.Args)
 	return call.Err
 }
 
-func (fw *firewalld) UntrustInterface(iface string) error {
+// Returns the interface a direct rule was added for, if it's one of ours.
+func (rule firewalldDirectRule) iface() string {
+	if len(rule.Args) >= 2 && rule.Args[0] == "-i" {
+		return rule.Args[1]
+	}
+
+	return ""
+}
+
+// Removes every direct rule whose interface matches the given filter.
+func (fw *firewalld) removeDirectRules(filter func(string) bool) error {
+	var rules []firewalldDirectRule
+	call := fw.firewalld.Call(firewalldDirectGetAllRulesMethod, defaultCallFlags)
+	if err := call.Store(&rules); err != nil {
+		return errors.Wrap(err, "getting direct rules")
+	}
+
+	for _, rule := range rules {
+		if iface := rule.iface(); iface != "" && filter(iface) {
+			if err := fw.removeDirectRule(rule); err != nil {
+				return errors.Wrapf(err, "removing direct rule for %s", iface)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fw *firewalld) AddInterface(iface string, egress EgressPolicy) error {
+	call := fw.firewalld.Call(firewalldZoneChangeInterfaceMethod, defaultCallFlags,
+		fw.zone, iface)
+	if call.Err != nil {
+		return call.Err
+	}
+
+	if !egress.Restrict {
+		return nil
+	}
+
+	for _, rule := range firewalldEgressRules(iface, egress) {
+		if err := fw.addDirectRule(rule); err != nil {
+			return errors.Wrapf(err, "adding egress rule %v", rule.Args)
+		}
+	}
+
+	return nil
+}
+
+func (fw *firewalld) RemoveInterface(iface string) error {
+	if err := fw.removeDirectRules(func(ruleIface string) bool { return ruleIface == iface }); err != nil {
+		return err
+	}
+
 	call := fw.firewalld.Call(firewalldZoneRemoveInterfaceMethod, defaultCallFlags,
-		trustedZone, iface)
+		fw.zone, iface)
 	return call.Err
 }
 
+func (fw *firewalld) Prune(assumedPrefix string) error {
+	isStale := func(iface string) bool {
+		if isStaleInterface(assumedPrefix, iface) {
+			log.Debug("Pruning stale interface:", iface)
+			return true
+		}
+
+		return false
+	}
+
+	if err := fw.removeDirectRules(isStale); err != nil {
+		return err
+	}
+
+	for _, zone := range []string{nsboxZone, trustedZone} {
+		var ifaces []string
+		call := fw.firewalld.Call(firewalldZoneGetInterfacesMethod, defaultCallFlags, zone)
+		if err := call.Store(&ifaces); err != nil {
+			log.Debugf("Failed to get interfaces of zone %s: %v", zone, err)
+			continue
+		}
+
+		for _, iface := range ifaces {
+			if isStale(iface) {
+				call := fw.firewalld.Call(firewalldZoneRemoveInterfaceMethod, defaultCallFlags,
+					zone, iface)
+				if call.Err != nil {
+					return errors.Wrapf(call.Err, "removing %s from zone %s", iface, zone)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func (fw *firewalld) Close() error {
 	if err := fw.systemBus.Close(); err != nil {
 		return err
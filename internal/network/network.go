@@ -5,68 +5,156 @@
 package network
 
 import (
-	"math/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
 	"github.com/vishvananda/netlink"
 )
 
 type Firewall interface {
-	TrustInterface(string) error
-	UntrustInterface(string) error
+	// Allows the given container interface through the firewall, restricting the traffic it
+	// may send out through the host to the given policy.
+	AddInterface(string, EgressPolicy) error
+	RemoveInterface(string) error
+	// Removes any leftover rules for nsbox interfaces (with the given link prefix) that no
+	// longer exist.
+	Prune(string) error
 	Close() error
 }
 
-func GetFirewall() Firewall {
-	if fw := newFirewalld(); fw != nil {
-		return fw
+type FirewallBackend int
+
+const (
+	FirewallAuto FirewallBackend = iota
+	FirewallFirewalld
+	FirewallNftables
+	FirewallNone
+)
+
+var (
+	firewallBackendToString = map[FirewallBackend]string{
+		FirewallAuto:      "auto",
+		FirewallFirewalld: "firewalld",
+		FirewallNftables:  "nftables",
+		FirewallNone:      "none",
 	}
 
-	return nil
+	stringToFirewallBackend = map[string]FirewallBackend{
+		"auto":      FirewallAuto,
+		"firewalld": FirewallFirewalld,
+		"nftables":  FirewallNftables,
+		"none":      FirewallNone,
+	}
+)
+
+func (backend FirewallBackend) String() string {
+	return firewallBackendToString[backend]
 }
 
-// Short prefix, because otherwise the name will be too long (IFNAMSIZ is only 16).
-const nsboxPrefix = "nx-"
+func (backend *FirewallBackend) Set(value string) error {
+	newBackend, ok := stringToFirewallBackend[strings.ToLower(value)]
+	if !ok {
+		return errors.New("invalid firewall backend")
+	}
 
-func GenerateUniqueLinkName(base string, assumedPrefix string) (string, error) {
-	// XXX: This is a tad racy, as it's technically possible someone else
-	// might claim this link name right before we do.
-	// The "algorithm" is also stupid as heck, but it should generally work.
+	*backend = newBackend
+	return nil
+}
 
-	links, err := netlink.LinkList()
+// Reads the firewall backend override from the host configuration, defaulting to FirewallAuto
+// if none is present.
+func ConfiguredFirewallBackend() (FirewallBackend, error) {
+	data, err := ioutil.ReadFile(paths.GetFirewallBackendConfig())
 	if err != nil {
-		return "", errors.Wrap(err, "getting netlink list")
+		if os.IsNotExist(err) {
+			return FirewallAuto, nil
+		}
+
+		return FirewallAuto, errors.Wrap(err, "failed to read firewall backend config")
 	}
 
-	linkNames := map[string]interface{}{}
-	for _, link := range links {
-		linkNames[link.Attrs().Name] = nil
+	var backend FirewallBackend
+	if err := backend.Set(strings.TrimSpace(string(data))); err != nil {
+		return FirewallAuto, err
 	}
 
-	maxBaseLength := netlink.IFNAMSIZ - len(assumedPrefix) - len(nsboxPrefix) - 1
+	return backend, nil
+}
 
-	name := nsboxPrefix
-	if len(base) > maxBaseLength {
-		name += base[:maxBaseLength]
-	} else {
-		name += base
+func GetFirewall() Firewall {
+	backend, err := ConfiguredFirewallBackend()
+	if err != nil {
+		log.Alert("WARNING: using automatic firewall backend:", err)
 	}
 
-	unique := false
+	log.Debug("Firewall backend:", backend)
 
-	for i := len(name) - 1; i >= len(nsboxPrefix); i-- {
-		if _, exists := linkNames[name]; exists {
-			randLetter := rune(rand.Intn(26) + 'a')
-			name = name[:i] + string(randLetter) + name[i+1:]
-		} else {
-			unique = true
-			break
+	switch backend {
+	case FirewallAuto:
+		if fw := newFirewalld(); fw != nil {
+			return fw
 		}
+
+		if fw := newNftables(); fw != nil {
+			return fw
+		}
+	case FirewallFirewalld:
+		if fw := newFirewalld(); fw != nil {
+			return fw
+		}
+
+		log.Alert("WARNING: firewalld backend was requested but is unavailable")
+	case FirewallNftables:
+		if fw := newNftables(); fw != nil {
+			return fw
+		}
+
+		log.Alert("WARNING: nftables backend was requested but is unavailable")
+	case FirewallNone:
+		break
+	}
+
+	return nil
+}
+
+// Returns true if the given interface looks like one nsbox created and no longer exists.
+func isStaleInterface(assumedPrefix, iface string) bool {
+	if !strings.HasPrefix(iface, assumedPrefix+nsboxPrefix) {
+		return false
 	}
 
-	if !unique {
-		return "", errors.Errorf("could not generate unique interface name for %s", base)
+	_, err := netlink.LinkByName(iface)
+	return err != nil
+}
+
+// Short prefix, because otherwise the name will be too long (IFNAMSIZ is only 16).
+const nsboxPrefix = "nx-"
+
+// Maximum number of characters of the readable base to include in a link name. Everything else
+// that fits goes to the hash, since a collision would silently put two networks on one link.
+const maxLinkBaseLength = 3
+
+// Link names can only safely contain lowercase letters and digits, so the hash is encoded with
+// the lowercase base32 alphabet, which fits 5 bits in each character instead of hex's 4.
+var linkHashEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Generates a stable link name, containing the start of the readable base, followed by as much
+// of a hash of the unique identifier as will fit (at least 30 bits). The same inputs will always
+// give the same name, so several containers can agree on a link without any coordination.
+func StableLinkName(base, unique, assumedPrefix string) string {
+	if len(base) > maxLinkBaseLength {
+		base = base[:maxLinkBaseLength]
 	}
 
-	return name, nil
+	// IFNAMSIZ includes the trailing NUL.
+	hashLength := netlink.IFNAMSIZ - 1 - len(assumedPrefix) - len(nsboxPrefix) - len(base)
+
+	hash := sha256.Sum256([]byte(unique))
+	return nsboxPrefix + base + linkHashEncoding.EncodeToString(hash[:])[:hashLength]
 }
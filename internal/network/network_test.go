@@ -0,0 +1,45 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package network
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestStableLinkName(t *testing.T) {
+	validName := regexp.MustCompile(`^nx-[a-z0-9_-]+$`)
+
+	for _, test := range []struct {
+		base, unique string
+	}{
+		{"a", "user/a"},
+		{"web", "user/web"},
+		{"a-very-long-network-name", "user/a-very-long-network-name"},
+	} {
+		name := StableLinkName(test.base, test.unique, "vz-")
+
+		if len("vz-"+name) != netlink.IFNAMSIZ-1 {
+			t.Errorf("%s: link name %s does not use the full IFNAMSIZ budget", test.base, name)
+		}
+
+		if !validName.MatchString(name) {
+			t.Errorf("%s: invalid link name %s", test.base, name)
+		}
+
+		if again := StableLinkName(test.base, test.unique, "vz-"); again != name {
+			t.Errorf("%s: link name is unstable (%s != %s)", test.base, name, again)
+		}
+	}
+
+	// Names that share a truncated base must still differ.
+	first := StableLinkName("network-one", "user/network-one", "vz-")
+	second := StableLinkName("network-two", "user/network-two", "vz-")
+	if first == second {
+		t.Errorf("network-one and network-two both got the link name %s", first)
+	}
+}
@@ -0,0 +1,335 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package network
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"golang.org/x/sys/unix"
+)
+
+// All of nsbox's rules live inside its own table, so they can never clobber anything else on
+// the host. Every rule's user data is set to the interface it was added for, which is how they
+// get found again for removal.
+const nftablesTableName = "nsbox"
+
+type nftablesFirewall struct {
+	conn        *nftables.Conn
+	table       *nftables.Table
+	input       *nftables.Chain
+	forward     *nftables.Chain
+	postrouting *nftables.Chain
+}
+
+func newNftables() *nftablesFirewall {
+	conn := &nftables.Conn{}
+
+	if _, err := conn.ListTables(); err != nil {
+		log.Debug("Failed to list nftables tables:", err)
+		return nil
+	}
+
+	fw := &nftablesFirewall{conn: conn}
+
+	// Adding an existing table or chain is a no-op, so this is safe to do on every run.
+	fw.table = conn.AddTable(&nftables.Table{
+		Family: nftables.TableFamilyINet,
+		Name:   nftablesTableName,
+	})
+
+	fw.input = conn.AddChain(&nftables.Chain{
+		Name:     "input",
+		Table:    fw.table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	fw.forward = conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    fw.table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	fw.postrouting = conn.AddChain(&nftables.Chain{
+		Name:     "postrouting",
+		Table:    fw.table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+
+	if err := conn.Flush(); err != nil {
+		log.Debug("Failed to create nftables table:", err)
+		return nil
+	}
+
+	return fw
+}
+
+func nftablesIfname(iface string) []byte {
+	data := make([]byte, unix.IFNAMSIZ)
+	copy(data, iface+"\x00")
+	return data
+}
+
+func nftablesMatchIface(key expr.MetaKey, op expr.CmpOp, iface string) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: op, Register: 1, Data: nftablesIfname(iface)},
+	}
+}
+
+func (fw *nftablesFirewall) addRule(chain *nftables.Chain, iface string, exprs ...[]expr.Any) {
+	rule := &nftables.Rule{
+		Table:    fw.table,
+		Chain:    chain,
+		UserData: []byte(iface),
+	}
+
+	for _, part := range exprs {
+		rule.Exprs = append(rule.Exprs, part...)
+	}
+
+	fw.conn.AddRule(rule)
+}
+
+// Matches traffic going to the given destination network.
+func nftablesMatchDestination(ipnet *net.IPNet) []expr.Any {
+	var family byte
+	var offset uint32
+	ip := ipnet.IP
+
+	if ip4 := ip.To4(); ip4 != nil {
+		family = unix.NFPROTO_IPV4
+		offset = 16
+		ip = ip4
+	} else {
+		family = unix.NFPROTO_IPV6
+		offset = 24
+		ip = ip.To16()
+	}
+
+	length := uint32(len(ip))
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{family}},
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          length,
+		},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            length,
+			Mask:           []byte(ipnet.Mask),
+			Xor:            make([]byte, length),
+		},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte(ip.Mask(ipnet.Mask))},
+	}
+}
+
+// Matches traffic of the given layer 4 protocol going to the given port.
+func nftablesMatchPort(proto byte, port uint16) []expr.Any {
+	portData := make([]byte, 2)
+	binary.BigEndian.PutUint16(portData, port)
+
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       2,
+			Len:          2,
+		},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: portData},
+	}
+}
+
+// The chains nsbox's rules can be added to.
+type nftablesChain int
+
+const (
+	nftablesInput nftablesChain = iota
+	nftablesForward
+	nftablesPostrouting
+)
+
+// A rule to add to one of nsbox's chains, for a single interface.
+type nftablesRule struct {
+	chain nftablesChain
+	exprs []expr.Any
+}
+
+// Builds rules accepting traffic from the interface that matches any of the given rules.
+func nftablesAllowRules(chain nftablesChain, iface string, egressRules []EgressRule) []nftablesRule {
+	accept := []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}}
+	fromIface := nftablesMatchIface(expr.MetaKeyIIFNAME, expr.CmpOpEq, iface)
+
+	rules := []nftablesRule{}
+	for _, rule := range egressRules {
+		var destination []expr.Any
+		if rule.Net != nil {
+			destination = nftablesMatchDestination(rule.Net)
+		}
+
+		if rule.Port == 0 {
+			rules = append(rules, nftablesRule{chain, nftablesJoin(fromIface, destination, accept)})
+		} else {
+			for _, proto := range []byte{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+				rules = append(rules, nftablesRule{chain, nftablesJoin(fromIface, destination,
+					nftablesMatchPort(proto, rule.Port), accept)})
+			}
+		}
+	}
+
+	return rules
+}
+
+func nftablesJoin(parts ...[]expr.Any) []expr.Any {
+	var exprs []expr.Any
+	for _, part := range parts {
+		exprs = append(exprs, part...)
+	}
+
+	return exprs
+}
+
+// Builds every rule needed for a container's interface under the given egress policy.
+func nftablesInterfaceRules(iface string, egress EgressPolicy) []nftablesRule {
+	accept := []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}}
+	reject := []expr.Any{
+		&expr.Counter{},
+		&expr.Reject{
+			Type: unix.NFT_REJECT_ICMPX_UNREACH,
+			Code: unix.NFT_REJECT_ICMPX_ADMIN_PROHIBITED,
+		},
+	}
+	fromIface := nftablesMatchIface(expr.MetaKeyIIFNAME, expr.CmpOpEq, iface)
+	toIface := nftablesMatchIface(expr.MetaKeyOIFNAME, expr.CmpOpEq, iface)
+
+	rules := []nftablesRule{}
+
+	if !egress.Restrict {
+		// Allow the container to talk to the host (DHCP, DNS, etc) and anywhere else.
+		rules = append(rules,
+			nftablesRule{nftablesInput, nftablesJoin(fromIface, accept)},
+			nftablesRule{nftablesForward, nftablesJoin(fromIface, accept)})
+	} else {
+		// Replies to connections the host made into the container.
+		established := []expr.Any{
+			&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            4,
+				Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+				Xor:            binaryutil.NativeEndian.PutUint32(0),
+			},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+		}
+		// IPv6 can't even find its neighbors without ICMPv6.
+		icmpv6 := []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_ICMPV6}},
+		}
+
+		rules = append(rules,
+			nftablesRule{nftablesInput, nftablesJoin(fromIface, established, accept)},
+			nftablesRule{nftablesInput, nftablesJoin(fromIface, icmpv6, accept)})
+		rules = append(rules, nftablesAllowRules(nftablesInput, iface, egress.InputRules())...)
+		rules = append(rules, nftablesRule{nftablesInput, nftablesJoin(fromIface, reject)})
+
+		rules = append(rules, nftablesAllowRules(nftablesForward, iface, egress.Rules)...)
+		rules = append(rules, nftablesRule{nftablesForward, nftablesJoin(fromIface, reject)})
+	}
+
+	// Allow traffic back into the container.
+	rules = append(rules, nftablesRule{nftablesForward, nftablesJoin(toIface, accept)})
+
+	// Masquerade anything leaving the container's network.
+	rules = append(rules, nftablesRule{nftablesPostrouting, nftablesJoin(fromIface,
+		nftablesMatchIface(expr.MetaKeyOIFNAME, expr.CmpOpNeq, iface),
+		[]expr.Any{&expr.Counter{}, &expr.Masq{}})})
+
+	return rules
+}
+
+func (fw *nftablesFirewall) AddInterface(iface string, egress EgressPolicy) error {
+	// Make sure there aren't any duplicate rules from a previous run that wasn't cleaned up.
+	if err := fw.deleteRules(func(ruleIface string) bool { return ruleIface == iface }); err != nil {
+		return err
+	}
+
+	chains := map[nftablesChain]*nftables.Chain{
+		nftablesInput:       fw.input,
+		nftablesForward:     fw.forward,
+		nftablesPostrouting: fw.postrouting,
+	}
+
+	for _, rule := range nftablesInterfaceRules(iface, egress) {
+		fw.addRule(chains[rule.chain], iface, rule.exprs)
+	}
+
+	if err := fw.conn.Flush(); err != nil {
+		return errors.Wrapf(err, "adding nftables rules for %s", iface)
+	}
+
+	return nil
+}
+
+// Deletes every rule whose interface matches the given filter.
+func (fw *nftablesFirewall) deleteRules(filter func(string) bool) error {
+	for _, chain := range []*nftables.Chain{fw.input, fw.forward, fw.postrouting} {
+		rules, err := fw.conn.GetRules(fw.table, chain)
+		if err != nil {
+			return errors.Wrapf(err, "listing nftables rules in %s", chain.Name)
+		}
+
+		for _, rule := range rules {
+			if filter(string(rule.UserData)) {
+				if err := fw.conn.DelRule(rule); err != nil {
+					return errors.Wrapf(err, "deleting nftables rule in %s", chain.Name)
+				}
+			}
+		}
+	}
+
+	if err := fw.conn.Flush(); err != nil {
+		return errors.Wrap(err, "deleting nftables rules")
+	}
+
+	return nil
+}
+
+func (fw *nftablesFirewall) RemoveInterface(iface string) error {
+	return fw.deleteRules(func(ruleIface string) bool { return ruleIface == iface })
+}
+
+func (fw *nftablesFirewall) Prune(assumedPrefix string) error {
+	return fw.deleteRules(func(ruleIface string) bool {
+		if isStaleInterface(assumedPrefix, ruleIface) {
+			log.Debug("Pruning stale interface:", ruleIface)
+			return true
+		}
+
+		return false
+	})
+}
+
+func (fw *nftablesFirewall) Close() error {
+	return nil
+}
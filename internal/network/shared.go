@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package network
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+// A named virtual network that several containers can join, by all sharing the same
+// nspawn network zone.
+type SharedNetwork struct {
+	Name string
+	// The unique identifier the zone's link name is derived from. This is saved on creation,
+	// so the link name remains stable even if the naming scheme changes later on.
+	LinkId string
+}
+
+func validateSharedName(name string) error {
+	if matched, _ := regexp.MatchString(`^[a-zA-Z0-9_-]+$`, name); !matched {
+		return errors.Errorf("invalid network name: %s", name)
+	}
+
+	return nil
+}
+
+func CreateShared(usrdata *userdata.Userdata, name string) (*SharedNetwork, error) {
+	if err := validateSharedName(name); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(paths.NetworkInventory(usrdata), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create network inventory")
+	}
+
+	net := &SharedNetwork{
+		Name:   name,
+		LinkId: usrdata.User.Username + "/" + name,
+	}
+
+	// O_EXCL makes sure two concurrent creations can't clobber each other.
+	file, err := os.OpenFile(paths.NetworkData(usrdata, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errors.Errorf("network %s already exists", name)
+		}
+
+		return nil, errors.Wrap(err, "failed to create network file")
+	}
+
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(net); err != nil {
+		return nil, errors.Wrap(err, "failed to write network file")
+	}
+
+	return net, nil
+}
+
+func OpenShared(usrdata *userdata.Userdata, name string) (*SharedNetwork, error) {
+	if err := validateSharedName(name); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(paths.NetworkData(usrdata, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("network %s does not exist", name)
+		}
+
+		return nil, errors.Wrap(err, "failed to read network file")
+	}
+
+	defer file.Close()
+
+	var net SharedNetwork
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&net); err != nil {
+		return nil, errors.Wrap(err, "failed to parse network file")
+	}
+
+	net.Name = name
+	return &net, nil
+}
+
+func ListShared(usrdata *userdata.Userdata) ([]*SharedNetwork, error) {
+	networks := []*SharedNetwork{}
+
+	items, err := ioutil.ReadDir(paths.NetworkInventory(usrdata))
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debug("network directory does not exist")
+			return networks, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read network inventory")
+	}
+
+	for _, item := range items {
+		if !strings.HasSuffix(item.Name(), ".json") || item.IsDir() {
+			log.Debug("skipping item ", item.Name())
+			continue
+		}
+
+		net, err := OpenShared(usrdata, strings.TrimSuffix(item.Name(), filepath.Ext(item.Name())))
+		if err != nil {
+			log.Alertf("WARNING: failed to open network %s: %v", item.Name(), err)
+			continue
+		}
+
+		networks = append(networks, net)
+	}
+
+	return networks, nil
+}
+
+func (net SharedNetwork) Remove(usrdata *userdata.Userdata) error {
+	if err := os.Remove(paths.NetworkData(usrdata, net.Name)); err != nil {
+		return errors.Wrap(err, "failed to remove network file")
+	}
+
+	return nil
+}
+
+// Returns the name of the nspawn zone, which is also the zone link name without the "vz-"
+// prefix added by nspawn.
+func (net SharedNetwork) ZoneName(assumedPrefix string) string {
+	return StableLinkName(net.Name, "shared:"+net.LinkId, assumedPrefix)
+}
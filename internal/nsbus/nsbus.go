@@ -6,43 +6,43 @@ package nsbus
 
 import (
 	"fmt"
-	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"unsafe"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/pkg/errors"
-	"github.com/refi64/go-lxtempdir"
-	"github.com/refi64/nsbox/internal/log"
 	"golang.org/x/sys/unix"
 )
 
 /*
 	nsbus works by entering the mount namespace in a forked subprocess and
-	connecting to the D-Bus socket there. However, godbus's NewConn will not
-	give us a UNIX-style transport if we immediately create a connection from
-	the new fd, thus making passing of file descriptors broken. In order to
-	rectify this, a private (thanks to mounting a tmpfs in transient.go) D-Bus
-	socket is created that just forwards everything to the true socket, and
-	the D-Bus connection is created by dialing into the forwarding socket
-	instead of the true one.
+	connecting to a socket there (e.g. D-Bus or varlink), which works for any
+	socket that the process inside the namespace could connect to.
+
+	However, godbus's NewConn will not give us a UNIX-style transport if we
+	immediately create a connection from the new fd, thus making passing of
+	file descriptors broken. In order to rectify this, a private (thanks to
+	mounting a tmpfs in transient.go) socket is created by a Proxy that just
+	forwards everything to the true socket, and the D-Bus connection is
+	created by dialing into the forwarding socket instead of the true one.
+	The forwarding carries over any file descriptors sent alongside the data,
+	so they can still be passed to the container.
 */
 
 const PrivateBusTmpdir = "/tmp/nsbox"
-const privateBusPath = PrivateBusTmpdir + "/container_bus_socket"
-const busPath = "/run/dbus/system_bus_socket"
 
-// Use an error value to propagate the fd up from inside the dialing.
-type dialSuccessError struct {
-	fd uintptr
-}
+const SystemBusPath = "/run/dbus/system_bus_socket"
 
-func (e *dialSuccessError) Error() string {
-	return "dial success"
+// Returns the path to the user's session bus inside the container.
+func UserBusPath(uid int) string {
+	return fmt.Sprintf("/run/user/%d/bus", uid)
 }
 
+// The child's exit status when it fails to connect, as opposed to failing to enter the
+// namespace (where the exit status is just the errno).
+const connectFailedStatusBase = 128
+
 //go:linkname runtime_BeforeFork syscall.runtime_BeforeFork
 func runtime_BeforeFork()
 
@@ -70,70 +70,41 @@ func forkAndRunChild(nsfd, sock int, sockaddr *unix.RawSockaddrUnix, sockaddrLen
 
 	if _, _, syserr = unix.RawSyscall(unix.SYS_CONNECT,
 		uintptr(sock), uintptr(unsafe.Pointer(sockaddr)), sockaddrLen); syserr != 0 {
-		unix.Exit(128 + int(syserr))
+		unix.Exit(connectFailedStatusBase + int(syserr))
 	}
 
 	unix.Exit(0)
 	panic("exit failed?")
 }
 
-func copyForever(dest io.Writer, src io.Reader) {
-	for {
-
-		if _, err := io.Copy(dest, src); err != nil {
-			log.Fatal("Copying D-Bus forwarder connection data:", err)
-		}
-	}
-}
-
-func forwardListenerToSocket(listener net.Listener, sockfile *os.File) {
-	conn, err := listener.Accept()
-	listener.Close()
-	if err != nil {
-		sockfile.Close()
-		log.Fatal("Accepting D-Bus forwarder connection:", err)
+// Connects to the UNIX socket at the given path, inside the mount namespace of the process. The
+// connection is made directly, so file descriptors can be passed over it.
+func DialInsideNamespace(nspid int, path string) (*net.UnixConn, error) {
+	var sockaddr unix.RawSockaddrUnix
+	if len(path) >= len(sockaddr.Path) {
+		return nil, errors.Errorf("socket path is too long: %s", path)
 	}
 
-	go copyForever(conn, sockfile)
-	go copyForever(sockfile, conn)
-}
-
-func DialBusInsideNamespace(nspid int) (*dbus.Conn, error) {
-	// We don't worry about closing the directory, as it will be gone safely
-	// the moment that the nsbox service exits anyway.
-
-	forwardSockDir, err := lxtempdir.Create("", "nsbox-socket")
-	if err != nil {
-		return nil, errors.Wrap(err, "craete nsbus socket dir")
+	sockaddr.Family = unix.AF_UNIX
+	for i := 0; i < len(path); i++ {
+		sockaddr.Path[i] = int8(path[i])
 	}
 
-	forwardSockPath := filepath.Join(forwardSockDir.Path, "bus")
+	sockaddrLen := unsafe.Sizeof(sockaddr.Family) + uintptr(len(path)+1)
 
-	nsfd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/mnt", nspid), unix.O_RDONLY, 0)
+	nsfd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/mnt", nspid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
 	if err != nil {
 		return nil, errors.Wrap(err, "open mnt ns fd")
 	}
 	defer unix.Close(nsfd)
 
-	var sockfile *os.File
-
-	sock, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	sock, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, errors.Wrap(err, "create socket")
 	}
-	defer func() {
-		if sockfile == nil {
-			unix.Close(sock)
-		}
-	}()
 
-	var sockaddr unix.RawSockaddrUnix
-	sockaddr.Family = unix.AF_UNIX
-	busPathBytes := []byte(busPath)
-	copy(sockaddr.Path[:], *(*[]int8)(unsafe.Pointer(&busPathBytes)))
-	sockaddr.Path[len(busPathBytes)] = 0
-
-	sockaddrLen := unsafe.Sizeof(sockaddr.Family) + uintptr(len(busPathBytes)+1)
+	sockfile := os.NewFile(uintptr(sock), path)
+	defer sockfile.Close()
 
 	child, syserr := forkAndRunChild(nsfd, sock, &sockaddr, sockaddrLen)
 	runtime_AfterFork()
@@ -144,33 +115,63 @@ func DialBusInsideNamespace(nspid int) (*dbus.Conn, error) {
 
 	process, err := os.FindProcess(int(child))
 	if err != nil {
-		log.Fatal("FindProcess returned unexpected error:", err)
+		return nil, errors.Wrap(err, "find connector process")
 	}
 
 	state, err := process.Wait()
 	if err != nil {
-		return nil, errors.Wrap(err, "wait for bus connector")
+		return nil, errors.Wrap(err, "wait for connector")
+	}
+
+	if !state.Exited() {
+		return nil, errors.Errorf("connector: %v", state)
+	} else if status := state.ExitCode(); status >= connectFailedStatusBase {
+		return nil, errors.Wrapf(unix.Errno(status-connectFailedStatusBase), "connect to %s", path)
+	} else if status != 0 {
+		return nil, errors.Wrapf(unix.Errno(status), "enter mount namespace of %d", nspid)
+	}
+
+	// FileConn dups the socket, so the original is closed either way.
+	conn, err := net.FileConn(sockfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "wrap socket")
 	}
 
-	if !state.Exited() || state.ExitCode() != 0 {
-		return nil, errors.Errorf("bus connector: %v", state)
+	return conn.(*net.UnixConn), nil
+}
+
+// Connects to the D-Bus socket at the given path inside the mount namespace of the process. The
+// caller still has to authenticate the connection.
+func DialBusInsideNamespace(nspid int, busPath string) (*dbus.Conn, error) {
+	// Connect first, so any errors doing so aren't hidden behind the proxy.
+	target, err := DialInsideNamespace(nspid, busPath)
+	if err != nil {
+		return nil, err
 	}
 
-	forwardListener, err := net.Listen("unix", forwardSockPath)
+	proxy, err := listenProxy(nspid, busPath)
 	if err != nil {
-		unix.Close(sock)
-		return nil, errors.Wrap(err, "forward listener")
+		target.Close()
+		return nil, err
 	}
 
-	sockfile = os.NewFile(uintptr(sock), "d-bus forward socket")
+	// Only a single connection is needed, which is kept being forwarded until either side
+	// closes it.
+	defer proxy.StopAccepting()
 
-	bus, err := dbus.Dial("unix:path=" + forwardSockPath)
+	bus, err := dbus.Dial("unix:path=" + proxy.Path())
 	if err != nil {
-		unix.Close(sock)
-		forwardListener.Close()
+		target.Close()
 		return nil, errors.Wrap(err, "dialing forwarded bus socket")
 	}
 
-	go forwardListenerToSocket(forwardListener, sockfile)
+	client, err := proxy.listener.AcceptUnix()
+	if err != nil {
+		bus.Close()
+		target.Close()
+		return nil, errors.Wrap(err, "accepting forwarded bus connection")
+	}
+
+	proxy.forward(client, target)
 	return bus, nil
 }
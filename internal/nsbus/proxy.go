@@ -0,0 +1,262 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package nsbus
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/go-lxtempdir"
+	"github.com/refi64/nsbox/internal/log"
+	"golang.org/x/sys/unix"
+)
+
+// The most file descriptors that can be sent in a single message (SCM_MAX_FD).
+const maxForwardedFds = 253
+
+// A socket on the host that forwards every connection made to it to a socket inside a
+// container's mount namespace.
+type Proxy struct {
+	nspid  int
+	target string
+
+	dir      *lxtempdir.TempDir
+	listener *net.UnixListener
+
+	mutex     sync.Mutex
+	accepting bool
+	conns     map[*net.UnixConn]struct{}
+	wg        sync.WaitGroup
+}
+
+func listenProxy(nspid int, target string) (*Proxy, error) {
+	dir, err := lxtempdir.Create("", "nsbox-socket")
+	if err != nil {
+		return nil, errors.Wrap(err, "create proxy socket dir")
+	}
+
+	path := filepath.Join(dir.Path, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		os.RemoveAll(dir.Path)
+		dir.Close()
+		return nil, errors.Wrap(err, "proxy listener")
+	}
+
+	return &Proxy{
+		nspid:     nspid,
+		target:    target,
+		dir:       dir,
+		listener:  listener,
+		accepting: true,
+		conns:     map[*net.UnixConn]struct{}{},
+	}, nil
+}
+
+// Starts forwarding connections to the socket at the target path, inside the mount namespace of
+// the process.
+func NewProxy(nspid int, target string) (*Proxy, error) {
+	proxy, err := listenProxy(nspid, target)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy.wg.Add(1)
+	go proxy.acceptLoop()
+
+	return proxy, nil
+}
+
+// The path of the socket that connections should be made to.
+func (proxy *Proxy) Path() string {
+	return proxy.listener.Addr().String()
+}
+
+func (proxy *Proxy) acceptLoop() {
+	defer proxy.wg.Done()
+
+	for {
+		client, err := proxy.listener.AcceptUnix()
+		if err != nil {
+			proxy.mutex.Lock()
+			accepting := proxy.accepting
+			proxy.mutex.Unlock()
+
+			if accepting {
+				log.Alert("failed to accept proxy connection:", err)
+			}
+
+			return
+		}
+
+		target, err := DialInsideNamespace(proxy.nspid, proxy.target)
+		if err != nil {
+			log.Alertf("failed to connect to %s in the container: %v", proxy.target, err)
+			client.Close()
+			continue
+		}
+
+		proxy.forward(client, target)
+	}
+}
+
+// Starts forwarding between the two connections in the background, unless the proxy was
+// already closed.
+func (proxy *Proxy) forward(client, target *net.UnixConn) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	if proxy.conns == nil {
+		client.Close()
+		target.Close()
+		return
+	}
+
+	proxy.conns[client] = struct{}{}
+	proxy.conns[target] = struct{}{}
+
+	proxy.wg.Add(1)
+	go func() {
+		defer proxy.wg.Done()
+		proxy.forwardConnection(client, target)
+	}()
+}
+
+func (proxy *Proxy) untrack(conns ...*net.UnixConn) {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	for _, conn := range conns {
+		delete(proxy.conns, conn)
+	}
+}
+
+func (proxy *Proxy) forwardConnection(client, target *net.UnixConn) {
+	defer proxy.untrack(client, target)
+	defer client.Close()
+	defer target.Close()
+
+	errs := make(chan error, 2)
+
+	forward := func(dest, src *net.UnixConn) {
+		err := forwardMessages(dest, src)
+		if err == nil {
+			// Let the other side know there's nothing more coming, while still letting it
+			// finish sending its own data.
+			err = dest.CloseWrite()
+		}
+
+		errs <- err
+	}
+
+	go forward(target, client)
+	go forward(client, target)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			// Once either side fails, the connection can't be salvaged, and closing both makes
+			// the other forwarder stop too.
+			log.Debugf("stopped forwarding to %s: %v", proxy.target, err)
+			client.Close()
+			target.Close()
+		}
+	}
+}
+
+// Stops accepting new connections and removes the socket, but keeps forwarding the existing
+// ones until either side closes them.
+func (proxy *Proxy) StopAccepting() {
+	proxy.mutex.Lock()
+	if !proxy.accepting {
+		proxy.mutex.Unlock()
+		return
+	}
+
+	proxy.accepting = false
+	proxy.mutex.Unlock()
+
+	if err := proxy.listener.Close(); err != nil {
+		log.Debug("failed to close proxy listener:", err)
+	}
+
+	if err := os.RemoveAll(proxy.dir.Path); err != nil {
+		log.Debug("failed to remove proxy socket dir:", err)
+	}
+
+	if err := proxy.dir.Close(); err != nil {
+		log.Debug(err)
+	}
+}
+
+// Shuts down the proxy, closing every connection and waiting for the forwarding to stop.
+func (proxy *Proxy) Close() {
+	proxy.StopAccepting()
+
+	proxy.mutex.Lock()
+	conns := proxy.conns
+	proxy.conns = nil
+	proxy.mutex.Unlock()
+
+	for conn := range conns {
+		conn.Close()
+	}
+
+	proxy.wg.Wait()
+}
+
+// Copies data from src to dest, along with any file descriptors sent with it. Returns nil once
+// src is closed.
+func forwardMessages(dest, src *net.UnixConn) error {
+	buffer := make([]byte, 64*1024)
+	oob := make([]byte, unix.CmsgSpace(maxForwardedFds*4))
+
+	for {
+		n, oobn, _, _, err := src.ReadMsgUnix(buffer, oob)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		} else if n == 0 && oobn == 0 {
+			return nil
+		}
+
+		var fds []int
+		if oobn != 0 {
+			messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+			if err != nil {
+				return errors.Wrap(err, "parse control message")
+			}
+
+			for _, message := range messages {
+				if rights, err := unix.ParseUnixRights(&message); err == nil {
+					fds = append(fds, rights...)
+				}
+			}
+		}
+
+		written, _, err := dest.WriteMsgUnix(buffer[:n], oob[:oobn], nil)
+
+		// The receiving end gets its own copies, so ours aren't needed anymore.
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if written < n {
+			if _, err := dest.Write(buffer[written:n]); err != nil {
+				return err
+			}
+		}
+	}
+}
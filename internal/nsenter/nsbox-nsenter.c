@@ -0,0 +1,224 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+#define _GNU_SOURCE
+
+#include "nsbox-nsenter.h"
+
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+// Namespaces have to be entered *before* the Go runtime starts: setns on a mount namespace
+// fails once there are other threads sharing the filesystem information, and a user namespace
+// can only be entered by a single-threaded process. Therefore, this runs as a constructor,
+// when the helper process is started with the leader's PID in the environment.
+
+struct namespace {
+  const char *name;
+  int type;
+  int fd;
+};
+
+// This is the same order that nsenter uses: the user namespace goes first so that the others
+// can be entered with its privileges, and the mount namespace goes last so the others can
+// still be found via the host's /proc.
+static struct namespace namespaces[] = {
+    {"user", CLONE_NEWUSER, -1}, {"cgroup", CLONE_NEWCGROUP, -1}, {"ipc", CLONE_NEWIPC, -1},
+    {"uts", CLONE_NEWUTS, -1},   {"net", CLONE_NEWNET, -1},       {"pid", CLONE_NEWPID, -1},
+    {"mnt", CLONE_NEWNS, -1},
+};
+
+#define NAMESPACE_COUNT (sizeof(namespaces) / sizeof(namespaces[0]))
+
+static int error_fd = -1;
+static pid_t child_pid = -1;
+
+static void fail(const char *format, ...) {
+  char message[1024];
+
+  va_list args;
+  va_start(args, format);
+  vsnprintf(message, sizeof(message), format, args);
+  va_end(args);
+
+  int fd = error_fd != -1 ? error_fd : STDERR_FILENO;
+  dprintf(fd, "%s%s", message, error_fd != -1 ? "" : "\n");
+
+  _exit(NSBOX_NSENTER_FAILURE_STATUS);
+}
+
+static void write_file(const char *path, const char *contents) {
+  int fd = open(path, O_WRONLY | O_CLOEXEC);
+  if (fd == -1) {
+    fail("failed to open %s: %s", path, strerror(errno));
+  }
+
+  size_t len = strlen(contents);
+  if (write(fd, contents, len) != (ssize_t)len) {
+    int saved_errno = errno;
+    close(fd);
+    errno = saved_errno;
+    fail("failed to write to %s: %s", path, strerror(errno));
+  }
+
+  close(fd);
+}
+
+// Returns true if the given namespace file refers to the one this process is already in.
+static int is_current_namespace(int fd, const char *name) {
+  char path[64];
+  snprintf(path, sizeof(path), "/proc/self/ns/%s", name);
+
+  struct stat current, target;
+  if (stat(path, &current) == -1 || fstat(fd, &target) == -1) {
+    return 0;
+  }
+
+  return current.st_dev == target.st_dev && current.st_ino == target.st_ino;
+}
+
+static void forward_signal(int sig) {
+  if (child_pid != -1) {
+    kill(child_pid, sig);
+  }
+}
+
+// Stays around on the host side as the child's parent, passing signals along and exiting the
+// same way the child does.
+static void supervise_child() {
+  struct sigaction action = {0};
+  action.sa_handler = forward_signal;
+  sigemptyset(&action.sa_mask);
+
+  for (int sig = 1; sig < NSIG; sig++) {
+    if (sig == SIGKILL || sig == SIGSTOP || sig == SIGCHLD) {
+      continue;
+    }
+
+    sigaction(sig, &action, NULL);
+  }
+
+  int status;
+  while (waitpid(child_pid, &status, 0) == -1) {
+    if (errno != EINTR) {
+      fail("failed to wait for child: %s", strerror(errno));
+    }
+  }
+
+  if (WIFSIGNALED(status)) {
+    int sig = WTERMSIG(status);
+
+    signal(sig, SIG_DFL);
+
+    sigset_t unblock;
+    sigemptyset(&unblock);
+    sigaddset(&unblock, sig);
+    sigprocmask(SIG_UNBLOCK, &unblock, NULL);
+
+    kill(getpid(), sig);
+  }
+
+  _exit(WIFEXITED(status) ? WEXITSTATUS(status) : NSBOX_NSENTER_FAILURE_STATUS);
+}
+
+__attribute__((constructor)) static void nsbox_nsenter() {
+  const char *leader_env = getenv(NSBOX_NSENTER_LEADER_ENV);
+  if (leader_env == NULL || *leader_env == '\0') {
+    return;
+  }
+
+  const char *error_fd_env = getenv(NSBOX_NSENTER_ERROR_FD_ENV);
+  if (error_fd_env != NULL && *error_fd_env != '\0') {
+    error_fd = atoi(error_fd_env);
+  }
+
+  char *end;
+  long leader = strtol(leader_env, &end, 10);
+  if (*end != '\0' || leader <= 0) {
+    fail("invalid leader PID: %s", leader_env);
+  }
+
+  // All the namespaces are opened up-front, because after entering the mount namespace, /proc
+  // is the container's.
+  for (size_t i = 0; i < NAMESPACE_COUNT; i++) {
+    char path[64];
+    snprintf(path, sizeof(path), "/proc/%ld/ns/%s", leader, namespaces[i].name);
+
+    namespaces[i].fd = open(path, O_RDONLY | O_CLOEXEC);
+    if (namespaces[i].fd == -1) {
+      if (errno == ENOENT) {
+        // The kernel doesn't support this namespace type.
+        continue;
+      }
+
+      fail("failed to open %s namespace of %ld: %s", namespaces[i].name, leader, strerror(errno));
+    }
+  }
+
+  const char *cgroup = getenv(NSBOX_NSENTER_CGROUP_ENV);
+  if (cgroup != NULL && *cgroup != '\0') {
+    char path[4096], pid[32];
+    snprintf(path, sizeof(path), "%s/cgroup.procs", cgroup);
+    snprintf(pid, sizeof(pid), "%d", getpid());
+    write_file(path, pid);
+  }
+
+  const char *label = getenv(NSBOX_NSENTER_LABEL_ENV);
+  if (label != NULL && *label != '\0') {
+    // This is inherited by the child and takes effect when it execs the command.
+    write_file("/proc/self/attr/exec", label);
+  }
+
+  int entered_pid = 0;
+
+  for (size_t i = 0; i < NAMESPACE_COUNT; i++) {
+    if (namespaces[i].fd == -1) {
+      continue;
+    }
+
+    // Joining the namespace the process is already in would fail for user namespaces, and is
+    // pointless for everything else.
+    if (!is_current_namespace(namespaces[i].fd, namespaces[i].name)) {
+      if (setns(namespaces[i].fd, namespaces[i].type) == -1) {
+        fail("failed to enter %s namespace of %ld: %s", namespaces[i].name, leader,
+             strerror(errno));
+      }
+
+      if (namespaces[i].type == CLONE_NEWPID) {
+        entered_pid = 1;
+      }
+    }
+
+    close(namespaces[i].fd);
+  }
+
+  if (!entered_pid) {
+    return;
+  }
+
+  // Only children are created inside a newly entered PID namespace.
+  child_pid = fork();
+  if (child_pid == -1) {
+    fail("failed to fork into PID namespace: %s", strerror(errno));
+  } else if (child_pid == 0) {
+    return;
+  }
+
+  if (error_fd != -1) {
+    // Any further errors are the child's to report, and once it execs, the reader sees EOF.
+    close(error_fd);
+    error_fd = -1;
+  }
+
+  supervise_child();
+}
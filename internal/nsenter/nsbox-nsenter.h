@@ -0,0 +1,13 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+#pragma once
+
+// These must be kept in sync with nsenter.go.
+#define NSBOX_NSENTER_LEADER_ENV "_NSBOX_NSENTER_LEADER"
+#define NSBOX_NSENTER_CGROUP_ENV "_NSBOX_NSENTER_CGROUP"
+#define NSBOX_NSENTER_LABEL_ENV "_NSBOX_NSENTER_LABEL"
+#define NSBOX_NSENTER_ERROR_FD_ENV "_NSBOX_NSENTER_ERROR_FD"
+
+#define NSBOX_NSENTER_FAILURE_STATUS 125
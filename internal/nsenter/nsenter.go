@@ -0,0 +1,190 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Enters the namespaces of a running container, without needing util-linux's nsenter. The
+// current executable is re-run as a helper, which joins the namespaces before the Go runtime
+// starts (see nsbox-nsenter.c), then execs the command.
+package nsenter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/selinux"
+	"golang.org/x/sys/unix"
+)
+
+// #include "nsbox-nsenter.h"
+import "C"
+
+// These must be kept in sync with nsbox-nsenter.h.
+const (
+	leaderEnv  = "_NSBOX_NSENTER_LEADER"
+	cgroupEnv  = "_NSBOX_NSENTER_CGROUP"
+	labelEnv   = "_NSBOX_NSENTER_LABEL"
+	errorFdEnv = "_NSBOX_NSENTER_ERROR_FD"
+)
+
+const failureStatus = C.NSBOX_NSENTER_FAILURE_STATUS
+
+const unifiedCgroupRoot = "/sys/fs/cgroup"
+
+// By the time this runs, the constructor already entered the namespaces, so all that's left is
+// to exec the command.
+func init() {
+	if os.Getenv(leaderEnv) == "" {
+		return
+	}
+
+	errorFd := -1
+	if value := os.Getenv(errorFdEnv); value != "" {
+		if fd, err := strconv.Atoi(value); err == nil {
+			errorFd = fd
+			unix.CloseOnExec(fd)
+		}
+	}
+
+	for _, name := range []string{leaderEnv, cgroupEnv, labelEnv, errorFdEnv} {
+		os.Unsetenv(name)
+	}
+
+	command := os.Args[1:]
+	path, err := exec.LookPath(command[0])
+	if err == nil {
+		err = unix.Exec(path, command, os.Environ())
+	}
+
+	message := fmt.Sprintf("failed to run %s: %v", command[0], err)
+	if errorFd != -1 {
+		unix.Write(errorFd, []byte(message))
+	} else {
+		fmt.Fprintln(os.Stderr, message)
+	}
+
+	os.Exit(failureStatus)
+}
+
+// Returns the path to the unified hierarchy's cgroup of the process, or an empty string if it
+// isn't on one (i.e. on a pure cgroup v1 system).
+func processCgroup(pid int) (string, error) {
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read cgroup")
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) == 3 && parts[0] == "0" && parts[1] == "" {
+			for _, root := range []string{unifiedCgroupRoot, filepath.Join(unifiedCgroupRoot, "unified")} {
+				path := filepath.Join(root, parts[2])
+				if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err == nil {
+					return path, nil
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func helperEnv(leader int, errorFd int) ([]string, error) {
+	env := append(os.Environ(), fmt.Sprintf("%s=%d", leaderEnv, leader))
+
+	cgroup, err := processCgroup(leader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find the container's cgroup")
+	} else if cgroup != "" {
+		env = append(env, fmt.Sprintf("%s=%s", cgroupEnv, cgroup))
+	} else {
+		log.Debug("container is not on the unified cgroup hierarchy, not joining its cgroup")
+	}
+
+	if selinux.Enabled() {
+		currentLabel, err := selinux.GetCurrentLabel()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get current SELinux label")
+		}
+
+		execLabel, err := selinux.GetExecLabel(currentLabel)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debug("SELinux exec transition", currentLabel, "->", execLabel)
+		env = append(env, fmt.Sprintf("%s=%s", labelEnv, execLabel))
+	} else {
+		log.Debug("SELinux is disabled")
+	}
+
+	if errorFd != -1 {
+		env = append(env, fmt.Sprintf("%s=%d", errorFdEnv, errorFd))
+	}
+
+	return env, nil
+}
+
+// Starts the command inside the namespaces of the leader process, with the given stdio. Any
+// failure to get into the container is returned here, rather than showing up as an exit status.
+func Start(leader int, command []string, stdin, stdout, stderr *os.File) (*os.Process, error) {
+	errorReader, errorWriter, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create error pipe")
+	}
+
+	defer errorReader.Close()
+
+	// ExtraFiles start right after stdio.
+	env, err := helperEnv(leader, 3)
+	if err != nil {
+		errorWriter.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command("/proc/self/exe", command...)
+	cmd.Env = env
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{errorWriter}
+
+	err = cmd.Start()
+	errorWriter.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start namespace helper")
+	}
+
+	// The pipe is closed either when the command is exec'd, or when the helper fails.
+	message, err := ioutil.ReadAll(errorReader)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errors.Wrap(err, "failed to read from namespace helper")
+	}
+
+	if len(message) != 0 {
+		cmd.Wait()
+		return nil, errors.New(string(message))
+	}
+
+	return cmd.Process, nil
+}
+
+// Replaces the current process with the command, running inside the namespaces of the leader
+// process. Errors entering the container are printed by the helper itself.
+func Exec(leader int, command []string) error {
+	env, err := helperEnv(leader, -1)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"/proc/self/exe"}, command...)
+	return errors.Wrap(unix.Exec("/proc/self/exe", args, env), "failed to exec namespace helper")
+}
@@ -15,6 +15,23 @@ import (
 
 const NetworkZonePrefix = "vz-"
 
+type NetworkMode int
+
+const (
+	// Don't pass any networking options, sharing the host's network.
+	NetworkHost NetworkMode = iota
+	// --private-network, leaving only a loopback device.
+	NetworkPrivate
+	// --network-veth, optionally inside the zone given by NetworkZone.
+	NetworkVeth
+	// --network-bridge, attached to the bridge given by NetworkInterface.
+	NetworkBridge
+	// --network-macvlan, on top of the interface given by NetworkInterface.
+	NetworkMacvlan
+	// --network-ipvlan, on top of the interface given by NetworkInterface.
+	NetworkIpvlan
+)
+
 type BindMount struct {
 	Host      string
 	Dest      string
@@ -30,8 +47,9 @@ type Builder struct {
 	Boot             bool
 	KeepUnit         bool
 	PipeConsole      bool
-	NetworkVeth      bool
+	Network          NetworkMode
 	NetworkZone      string
+	NetworkInterface string
 	MachineDirectory string
 	LinkJournal      string
 	MachineName      string
@@ -103,9 +121,13 @@ func escapeMountPath(path string) (result string) {
 	return
 }
 
-func (builder *Builder) Build() []string {
+func (builder *Builder) Build() ([]string, error) {
 	if builder.MachineDirectory == "" {
-		panic(errors.New("MachineDirectory must be set"))
+		return nil, errors.New("a machine directory must be set")
+	}
+
+	if builder.Network >= NetworkBridge && builder.NetworkInterface == "" {
+		return nil, errors.New("a network interface must be set for this network mode")
 	}
 
 	args := []string{builder.nspawn}
@@ -130,15 +152,26 @@ func (builder *Builder) Build() []string {
 		addArg(&args, "pipe")
 	}
 
-	if builder.NetworkVeth {
+	switch builder.Network {
+	case NetworkHost:
+		break
+	case NetworkPrivate:
+		addArg(&args, "private-network")
+	case NetworkVeth:
 		addArg(&args, "network-veth")
+		maybeAddArgValue(&args, "network-zone", builder.NetworkZone)
+	case NetworkBridge:
+		addArgValue(&args, "network-bridge", builder.NetworkInterface)
+	case NetworkMacvlan:
+		addArgValue(&args, "network-macvlan", builder.NetworkInterface)
+	case NetworkIpvlan:
+		addArgValue(&args, "network-ipvlan", builder.NetworkInterface)
 	}
 
 	addArgValue(&args, "directory", builder.MachineDirectory)
 	maybeAddArgValue(&args, "link-journal", builder.LinkJournal)
 	maybeAddArgValue(&args, "machine", builder.MachineName)
 	maybeAddArgValue(&args, "hostname", builder.Hostname)
-	maybeAddArgValue(&args, "network-zone", builder.NetworkZone)
 	maybeAddArgValue(&args, "system-call-filter", builder.SystemCallFilter)
 
 	for _, capability := range builder.Capabilities {
@@ -160,5 +193,5 @@ func (builder *Builder) Build() []string {
 		addArgValue(&args, "bind", spec)
 	}
 
-	return append(args, builder.Command...)
+	return append(args, builder.Command...), nil
 }
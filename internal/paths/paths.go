@@ -19,20 +19,38 @@ import (
 const InContainerPrivPath = "/var/lib/.nsbox-priv"
 const HostServiceSocketName = "host-service.sock"
 const PtyServiceSocketName = "pty-service.sock"
+const SessionKeeperSocketName = "session-keeper.sock"
 const StorageRoot = config.StateDir + "/nsbox"
 
+// Where units for enabled containers are installed. This is always the admin unit directory,
+// regardless of the configured install prefix, since systemd won't look anywhere else.
+const SystemdUnitDir = "/etc/systemd/system"
+
+// The directory holding all of the user's containers and networks.
+func UserStorage(usrdata *userdata.Userdata) string {
+	return filepath.Join(StorageRoot, usrdata.User.Username)
+}
+
 func ContainerDefault(usrdata *userdata.Userdata) string {
-	return filepath.Join(StorageRoot, usrdata.User.Username, "default")
+	return filepath.Join(UserStorage(usrdata), "default")
 }
 
 func ContainerInventory(usrdata *userdata.Userdata) string {
-	return filepath.Join(StorageRoot, usrdata.User.Username, "inventory")
+	return filepath.Join(UserStorage(usrdata), "inventory")
 }
 
 func ContainerData(usrdata *userdata.Userdata, name string) string {
 	return filepath.Join(ContainerInventory(usrdata), name)
 }
 
+func NetworkInventory(usrdata *userdata.Userdata) string {
+	return filepath.Join(UserStorage(usrdata), "networks")
+}
+
+func NetworkData(usrdata *userdata.Userdata, name string) string {
+	return filepath.Join(NetworkInventory(usrdata), name+".json")
+}
+
 func GetExecutablePath() (self string, err error) {
 	self, err = os.Executable()
 	if err != nil {
@@ -57,7 +75,8 @@ func getPathRelativeToInstallRoot(subpaths ...string) (string, error) {
 	parts := []string{filepath.Dir(self), ".."}
 
 	// XXX: this is kinda hacked in.
-	if strings.HasSuffix(self, "nsboxd") || strings.HasSuffix(self, "nsbox-invoker") {
+	if strings.HasSuffix(self, "nsboxd") || strings.HasSuffix(self, "nsbox-invoker") ||
+		strings.HasSuffix(self, "nsbox-manager") {
 		// nsboxd is in ROOT/libexec/nsbox, so a level further down than the nsbox CLI.
 		parts = append(parts, "..")
 	}
@@ -93,6 +112,10 @@ func GetCustomImageDir(name string) string {
 	return filepath.Join(GetCustomImagesDir(), name)
 }
 
+func GetFirewallBackendConfig() string {
+	return filepath.Join(config.ConfigDir, "nsbox", "firewall-backend")
+}
+
 func GetReleaseDataDir() (string, error) {
 	return getPathRelativeToInstallRoot(config.ShareDir, config.ProductName, "release")
 }
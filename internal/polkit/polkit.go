@@ -0,0 +1,65 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Checks authorizations with polkit, for services that act on behalf of other processes.
+package polkit
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/config"
+)
+
+const (
+	authorityName      = "org.freedesktop.PolicyKit1"
+	authorityPath      = "/org/freedesktop/PolicyKit1/Authority"
+	authorityInterface = "org.freedesktop.PolicyKit1.Authority"
+)
+
+// Lets polkit ask the user to authenticate, instead of only checking implicit authorizations.
+const checkAllowUserInteraction = 1
+
+// The process whose authorization is being checked.
+type Subject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+// Returns the subject for the owner of the given unique bus name.
+func SystemBusNameSubject(name string) Subject {
+	return Subject{
+		Kind:    "system-bus-name",
+		Details: map[string]dbus.Variant{"name": dbus.MakeVariant(name)},
+	}
+}
+
+var ErrNotAuthorized = errors.New("not authorized")
+
+// Returns the full ID of one of the actions in dev.nsbox.policy, e.g. "create".
+func ActionId(action string) string {
+	return config.RdnsName + "." + action
+}
+
+// Checks if the subject is authorized to perform the action, returning ErrNotAuthorized if it's
+// not.
+func CheckAuthorization(bus *dbus.Conn, subject Subject, action string) error {
+	var result struct {
+		IsAuthorized bool
+		IsChallenge  bool
+		Details      map[string]string
+	}
+
+	authority := bus.Object(authorityName, authorityPath)
+	call := authority.Call(authorityInterface+".CheckAuthorization", 0, subject, ActionId(action),
+		map[string]string{}, uint32(checkAllowUserInteraction), "")
+	if err := call.Store(&result); err != nil {
+		return errors.Wrapf(err, "failed to check authorization for %s", action)
+	}
+
+	if !result.IsAuthorized {
+		return ErrNotAuthorized
+	}
+
+	return nil
+}
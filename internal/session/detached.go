@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/paths"
+	"github.com/refi64/nsbox/internal/sessionkeeper"
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+func sessionKeeperSocket(ct *container.Container) string {
+	return ct.StorageChild(paths.InContainerPrivPath, paths.SessionKeeperSocketName)
+}
+
+// Starts a session that's kept running by the container's session keeper, returning its name.
+// If name is empty, one will be picked.
+func StartDetachedSession(ct *container.Container, command []string, usrdata *userdata.Userdata,
+	noReplay bool, workdir, name string) (string, error) {
+	if len(command) == 0 {
+		command = []string{ct.Shell(usrdata), "-l"}
+	}
+
+	env := []string{}
+	for name, value := range usrdata.Environ {
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	options := sessionkeeper.CreateOptions{
+		Name:     name,
+		Uid:      os.Getuid(),
+		Cwd:      workdir,
+		NoReplay: noReplay,
+		Env:      env,
+		Command:  command,
+	}
+
+	return sessionkeeper.Create(sessionKeeperSocket(ct), options)
+}
+
+// Attaches to a detached session, returning its exit status if it exited while attached.
+func AttachSession(ct *container.Container, name string) (int, error) {
+	status, detached, err := sessionkeeper.Attach(sessionKeeperSocket(ct), name)
+	if err != nil {
+		return 0, err
+	}
+
+	if detached {
+		fmt.Fprintf(os.Stderr, "\r\nDetached from session %s.\r\n", name)
+	}
+
+	return status, nil
+}
+
+func ShowSessions(ct *container.Container) error {
+	sessions, err := sessionkeeper.List(sessionKeeperSocket(ct))
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "NAME\tSTARTED\tSTATUS\tCOMMAND")
+
+	for _, info := range sessions {
+		var status string
+		if !info.Running {
+			status = fmt.Sprintf("exited (%d)", info.ExitStatus)
+		} else if info.Clients != 0 {
+			status = fmt.Sprintf("attached (%d)", info.Clients)
+		} else {
+			status = "detached"
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", info.Name, humanize.Time(info.Started), status,
+			strings.Join(info.Command, " "))
+	}
+
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"os/signal"
 
 	krpty "github.com/creack/pty"
+	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/container"
 	"github.com/refi64/nsbox/internal/log"
 	"github.com/refi64/nsbox/internal/ptyservice"
@@ -19,9 +20,6 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// #include "nsbox-ptyfwd.h"
-import "C"
-
 type ptyIoFlags int
 
 const (
@@ -48,6 +46,8 @@ type processExitType int
 const (
 	processExitNormal = iota
 	processExitSignaled
+	// Killed by a signal that also dumped core.
+	processExitDumped
 )
 
 type processExitStatus struct {
@@ -67,12 +67,41 @@ type containerDoor interface {
 	Enter(ct *container.Container, spec *containerEntrySpec, usrdata *userdata.Userdata) (sessionHandle, error)
 }
 
-func forwardPtys(dst, src *os.File) error {
-	errmsg := C.nsbox_forward_pty(C.int(dst.Fd()), C.int(src.Fd()))
-	if errmsg != nil {
-		log.Fatal("Failed to forward PTYs:", C.GoString(errmsg))
+// Copies everything from src to dst until src is closed. If rec isn't nil, the output is also
+// recorded to it.
+func forwardPtys(dst, src *os.File, rec *recorder) {
+	buffer := make([]byte, 32*1024)
+
+	for {
+		n, err := unix.Read(int(src.Fd()), buffer)
+		if err != nil {
+			// EIO gets returned from transient TTY issues, so ignore it.
+			if err == unix.EINTR || err == unix.EIO {
+				continue
+			}
+
+			log.Fatal("Failed to forward PTYs:", err)
+		} else if n == 0 {
+			return
+		}
+
+		if rec != nil {
+			rec.output(buffer[:n])
+		}
+
+		for written := 0; written < n; {
+			count, err := unix.Write(int(dst.Fd()), buffer[written:n])
+			if err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+
+				log.Fatal("Failed to forward PTYs:", err)
+			}
+
+			written += count
+		}
 	}
-	return nil
 }
 
 func (spec containerEntrySpec) buildNsboxHostCommand() []string {
@@ -101,12 +130,15 @@ func (spec containerEntrySpec) buildNsboxHostCommand() []string {
 	for name, value := range spec.env {
 		cmd = append(cmd, fmt.Sprintf("%s=%s", name, value))
 	}
+	cmd = append(cmd, fmt.Sprintf("%s=%d", container.SessionEnvVar, os.Getpid()))
 
 	return append(cmd, spec.command...)
 }
 
+// Enters the container, returning the exit status of the command. If recordPath is non-empty,
+// or the container has RecordSessions set, the session's output is recorded.
 func EnterContainer(ct *container.Container, command []string, usrdata *userdata.Userdata,
-	noReplay bool, workdir string) (int, error) {
+	noReplay bool, workdir, recordPath string) (int, error) {
 	if len(command) == 0 {
 		command = []string{ct.Shell(usrdata), "-l"}
 	}
@@ -171,6 +203,52 @@ func EnterContainer(ct *container.Container, command []string, usrdata *userdata
 		}
 	}
 
+	// Find the terminal that the PTY should take its size from.
+	var sizeSource *os.File
+	for _, file := range stdio {
+		if spec.ptyIo&stdioPtyFlags[int(file.Fd())] != 0 {
+			sizeSource = file
+			break
+		}
+	}
+
+	var rec *recorder
+	userRecordPath := recordPath != ""
+	if userRecordPath || ct.Config.RecordSessions {
+		if forwardPtyToWriter == nil {
+			if userRecordPath {
+				return 0, errors.New("recording requires the output to be a terminal")
+			}
+
+			log.Debug("not recording session, since the output is not a terminal")
+		} else {
+			if !userRecordPath {
+				var err error
+				if recordPath, err = newRecordingPath(ct); err != nil {
+					return 0, err
+				}
+			}
+
+			rows, cols, err := krpty.Getsize(sizeSource)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to get terminal size")
+			}
+
+			rec, err = newRecorder(recordPath, userRecordPath, usrdata, command, cols, rows)
+			if err != nil {
+				return 0, err
+			}
+
+			log.Debug("recording session to", recordPath)
+
+			defer func() {
+				if err := rec.Close(); err != nil {
+					log.Alert("Failed to save recording:", err)
+				}
+			}()
+		}
+	}
+
 	spec.verbose = log.Verbose()
 	spec.uid = os.Getuid()
 	spec.cwd = workdir
@@ -179,23 +257,27 @@ func EnterContainer(ct *container.Container, command []string, usrdata *userdata
 	spec.command = command
 
 	var handle sessionHandle
+	// The terminal's state from before it was made raw.
+	var rawState *terminal.State
 
 	// Set-up the PTY forwarding.
 	if spec.ptyIo != 0 {
 		if forwardStdinToPty {
-			go forwardPtys(pty, os.Stdin)
+			go forwardPtys(pty, os.Stdin, nil)
 		}
 
 		if forwardPtyToWriter != nil {
-			go forwardPtys(forwardPtyToWriter, pty)
+			go forwardPtys(forwardPtyToWriter, pty, rec)
 		}
 
 		if forwardStdinToPty {
-			oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+			var err error
+			// Do NOT use := here, it's needed by the job control below.
+			rawState, err = terminal.MakeRaw(int(os.Stdin.Fd()))
 			if err != nil {
 				log.Debug("failed to make terminal raw", err)
 			} else {
-				defer terminal.Restore(int(os.Stdin.Fd()), oldState)
+				defer terminal.Restore(int(os.Stdin.Fd()), rawState)
 			}
 		}
 
@@ -208,26 +290,23 @@ func EnterContainer(ct *container.Container, command []string, usrdata *userdata
 			close(sigchan)
 		}()
 
-		inheritSize := func() {
-			for _, file := range stdio {
-				if spec.ptyIo&stdioPtyFlags[int(file.Fd())] != 0 {
-					krpty.InheritSize(file, pty)
-					break
-				}
-			}
-		}
-
-		inheritSize()
+		krpty.InheritSize(sizeSource, pty)
 
 		go func() {
 			for range sigchan {
-				inheritSize()
+				krpty.InheritSize(sizeSource, pty)
+
+				if rec != nil {
+					if rows, cols, err := krpty.Getsize(sizeSource); err == nil {
+						rec.resize(cols, rows)
+					}
+				}
 			}
 		}()
 	}
 
 	sigchan := make(chan os.Signal)
-	signal.Notify(sigchan, unix.SIGINT, unix.SIGTSTP, unix.SIGQUIT, unix.SIGHUP)
+	signal.Notify(sigchan, unix.SIGINT, unix.SIGTSTP, unix.SIGCONT, unix.SIGQUIT, unix.SIGHUP)
 
 	defer func() {
 		signal.Stop(sigchan)
@@ -236,26 +315,68 @@ func EnterContainer(ct *container.Container, command []string, usrdata *userdata
 
 	go func() {
 		for sig := range sigchan {
-			if handle != nil {
-				log.Debugf("Forwarding signal %v", sig)
-				handle.Signal(sig)
-			} else {
+			if handle == nil {
 				log.Debug("Ignoring signal sent with nil handle")
+				continue
+			}
+
+			log.Debugf("Forwarding signal %v", sig)
+			if err := handle.Signal(sig); err != nil {
+				log.Debugf("Failed to forward signal %v: %v", sig, err)
+			}
+
+			switch sig {
+			case unix.SIGTSTP:
+				// Once the session is stopped, stop ourselves too, so the shell that started us
+				// gets its terminal back.
+				if rawState != nil {
+					terminal.Restore(int(os.Stdin.Fd()), rawState)
+				}
+
+				if err := unix.Kill(os.Getpid(), unix.SIGSTOP); err != nil {
+					log.Debug("Failed to stop:", err)
+				}
+			case unix.SIGCONT:
+				// We were resumed (e.g. via fg), and the session was just resumed above.
+				if rawState != nil {
+					if _, err := terminal.MakeRaw(int(os.Stdin.Fd())); err != nil {
+						log.Debug("failed to make terminal raw", err)
+					}
+				}
 			}
 		}
 	}()
 
+	// Let the container know it's in use, so it won't be shut down for being idle.
+	if err := ct.NotifySessionEnter(); err != nil {
+		log.Debug("failed to notify host service of session:", err)
+	} else {
+		defer func() {
+			if err := ct.NotifySessionExit(); err != nil {
+				log.Debug("failed to notify host service of session exit:", err)
+			}
+		}()
+	}
+
 	handle, err := door.Enter(ct, &spec, usrdata)
 	if err != nil {
 		return 0, err
 	}
 
 	status, err := handle.Wait()
+	if err != nil {
+		return 0, err
+	}
 
-	if status.exitType == processExitSignaled {
+	switch status.exitType {
+	case processExitSignaled:
 		// Mimic the shell's exit code on signal.
 		return 128 + status.result, nil
-	} else {
+	case processExitDumped:
+		// Shells use the same exit code for core dumps, but they also say so.
+		fmt.Fprintf(os.Stderr, "%s (core dumped)\n", unix.SignalName(unix.Signal(status.result)))
+		return 128 + status.result, nil
+	default:
 		return status.result, nil
 	}
 }
@@ -7,13 +7,12 @@ package session
 import (
 	"os"
 	"os/exec"
-	"strconv"
 	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/container"
 	"github.com/refi64/nsbox/internal/log"
-	"github.com/refi64/nsbox/internal/selinux"
+	"github.com/refi64/nsbox/internal/nsenter"
 	"github.com/refi64/nsbox/internal/userdata"
 	"golang.org/x/sys/unix"
 )
@@ -27,7 +26,7 @@ type nsenterSessionHandle struct {
 	process *os.Process
 }
 
-// A door that enters the container environment via nsenter.
+// A door that enters the container environment by joining the namespaces of its leader.
 type nsenterDoor struct{}
 
 func convertStateToProcessExit(state *os.ProcessState) (*processExitStatus, error) {
@@ -35,7 +34,9 @@ func convertStateToProcessExit(state *os.ProcessState) (*processExitStatus, erro
 	// unix.WaitStatus.
 	waitStatus := unix.WaitStatus(state.Sys().(syscall.WaitStatus))
 
-	if waitStatus.Signaled() {
+	if waitStatus.Signaled() && waitStatus.CoreDump() {
+		return &processExitStatus{exitType: processExitDumped, result: int(waitStatus.Signal())}, nil
+	} else if waitStatus.Signaled() {
 		return &processExitStatus{exitType: processExitSignaled, result: int(waitStatus.Signal())}, nil
 	} else if waitStatus.Exited() {
 		return &processExitStatus{exitType: processExitNormal, result: waitStatus.ExitStatus()}, nil
@@ -53,7 +54,7 @@ func (handle *nsenterSessionHandle) Wait() (*processExitStatus, error) {
 	if err != nil {
 		// Handle ExitError in convertStateToProcessExit.
 		if _, ok := err.(*exec.ExitError); !ok {
-			return nil, errors.Wrap(err, "waiting for nsenter")
+			return nil, errors.Wrap(err, "waiting for session")
 		}
 	}
 
@@ -73,37 +74,22 @@ func (door *nsenterDoor) Enter(ct *container.Container,
 		return nil, errors.Wrap(err, "set NSBOX_INTERNAL")
 	}
 
-	nsenterCmd := []string{"nsenter", "-at", strconv.Itoa(int(leader))}
-	nsenterCmd = append(nsenterCmd, spec.buildNsboxHostCommand()...)
-
-	log.Debug("running:", nsenterCmd)
-
-	if err := selinux.SetExecProcessContextContainer(); err != nil {
-		log.Alert("failed to set exec context to enter container:", err)
-	}
+	command := spec.buildNsboxHostCommand()
+	log.Debug("entering namespaces of", leader, "to run:", command)
 
 	// If there's no pty, we can exec the command directly.
 	if spec.ptyPath == "" {
-		nsenter, err := exec.LookPath("nsenter")
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to find nsenter")
-		}
-
-		if err := unix.Exec(nsenter, nsenterCmd, os.Environ()); err != nil {
-			return nil, errors.Wrap(err, "failed to exec into namespace")
+		if err := nsenter.Exec(int(leader), command); err != nil {
+			return nil, err
 		}
 
 		panic("should not reach here")
 	}
 
-	cmd := exec.Command(nsenterCmd[0], nsenterCmd[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	process, err := nsenter.Start(int(leader), command, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to enter container")
 	}
 
-	return &nsenterSessionHandle{process: cmd.Process}, nil
+	return &nsenterSessionHandle{process: process}, nil
 }
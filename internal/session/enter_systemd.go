@@ -7,8 +7,8 @@ package session
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
+	"time"
 
 	systemd1 "github.com/coreos/go-systemd/v22/dbus"
 	godbus "github.com/godbus/dbus/v5"
@@ -19,6 +19,7 @@ import (
 	"github.com/refi64/nsbox/internal/nsbus"
 	"github.com/refi64/nsbox/internal/selinux"
 	"github.com/refi64/nsbox/internal/userdata"
+	"golang.org/x/sys/unix"
 )
 
 // We add the "import C" here, because enter.go has it, so if CGO_ENABLED=0, then enter.go
@@ -27,10 +28,9 @@ import (
 import "C"
 
 type systemdSessionHandle struct {
-	process     *os.Process
 	systemd     *systemd1.Conn
-	machineName string
 	serviceName string
+	updates     chan *systemd1.PropertiesUpdate
 }
 
 // A door that enters the container environment via a transient unit.
@@ -38,11 +38,15 @@ type systemdSessionHandle struct {
 // exits within the proper cgroups.
 type systemdDoor struct{}
 
-const cldExited = 1
+// Values of ExecMainCode, from the CLD_* codes in siginfo.
+const (
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
 
-type property struct {
-	name, value string
-}
+// How often the unit's state is checked, in case an update about it was missed.
+const unitStatePollInterval = time.Second
 
 func getServicePropertyInt(systemd *systemd1.Conn, service, name string) (int, error) {
 	prop, err := systemd.GetServiceProperty(service, name)
@@ -60,24 +64,52 @@ func getServicePropertyInt(systemd *systemd1.Conn, service, name string) (int, e
 }
 
 func (handle *systemdSessionHandle) Signal(signal os.Signal) error {
-	systemdKill := []string{
-		"systemctl", "--machine=" + handle.machineName, "kill", handle.serviceName,
+	sig, ok := signal.(unix.Signal)
+	if !ok {
+		return errors.Errorf("unsupported signal %v", signal)
 	}
 
-	cmd := exec.Command(systemdKill[0], systemdKill[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "killing %s:%s", handle.machineName, handle.serviceName)
+	// Every process in the unit gets the signal, the same way a terminal would send it to the
+	// entire foreground process group.
+	handle.systemd.KillUnit(handle.serviceName, int32(sig))
+	return nil
+}
+
+func (handle *systemdSessionHandle) isFinished() (bool, error) {
+	prop, err := handle.systemd.GetUnitProperty(handle.serviceName, "ActiveState")
+	if err != nil {
+		return false, errors.Wrapf(err, "get ActiveState of %s", handle.serviceName)
 	}
 
-	return nil
+	state, ok := prop.Value.Value().(string)
+	if !ok {
+		return false, errors.Errorf("%s:ActiveState is %v", handle.serviceName, prop.Value)
+	}
+
+	return state == "inactive" || state == "failed", nil
 }
 
 func (handle *systemdSessionHandle) Wait() (*processExitStatus, error) {
-	_, err := handle.process.Wait()
-	if err != nil {
-		return nil, errors.Wrap(err, "waiting for systemd-run")
+	for {
+		finished, err := handle.isFinished()
+		if err != nil {
+			return nil, err
+		} else if finished {
+			break
+		}
+
+	waitForUpdate:
+		for {
+			select {
+			case update := <-handle.updates:
+				// Updates are sent for every unit in the container.
+				if update.UnitName == handle.serviceName {
+					break waitForUpdate
+				}
+			case <-time.After(unitStatePollInterval):
+				break waitForUpdate
+			}
+		}
 	}
 
 	exitCode, err := getServicePropertyInt(handle.systemd, handle.serviceName, "ExecMainCode")
@@ -90,9 +122,12 @@ func (handle *systemdSessionHandle) Wait() (*processExitStatus, error) {
 		return nil, errors.Wrapf(err, "get ExecMainStatus of %s", handle.serviceName)
 	}
 
-	if exitCode == cldExited {
+	switch exitCode {
+	case cldExited:
 		return &processExitStatus{exitType: processExitNormal, result: exitStatus}, nil
-	} else {
+	case cldDumped:
+		return &processExitStatus{exitType: processExitDumped, result: exitStatus}, nil
+	default:
 		return &processExitStatus{exitType: processExitSignaled, result: exitStatus}, nil
 	}
 }
@@ -110,13 +145,8 @@ func (door *systemdDoor) Enter(ct *container.Container, spec *containerEntrySpec
 
 	var handle *systemdSessionHandle
 
-	// XXX: We need to use systemd-run *and* go-systemd:
-	// - go-systemd is needed to gather the command results...
-	// - ...but godbus's fd passing is broken, so we need to use systemd-run to actually
-	//   start the process.
-
 	systemd, err := systemd1.NewConnection(func() (*godbus.Conn, error) {
-		conn, err := nsbus.DialBusInsideNamespace(int(leader))
+		conn, err := nsbus.DialBusInsideNamespace(int(leader), nsbus.SystemBusPath)
 		if err != nil {
 			return nil, errors.Wrap(err, "dialing bus in ns")
 		}
@@ -147,12 +177,30 @@ func (door *systemdDoor) Enter(ct *container.Container, spec *containerEntrySpec
 		}
 	}()
 
+	// Subscribe before the unit is started, so its exit can't be missed.
+	if err := systemd.Subscribe(); err != nil {
+		return nil, errors.Wrap(err, "subscribe to container systemd")
+	}
+
+	updates := make(chan *systemd1.PropertiesUpdate, 256)
+	systemd.SetPropertiesSubscriber(updates, make(chan error, 1))
+
 	serviceName := fmt.Sprintf("nsbox-entry-%s.service", uuid.New().String())
 
-	systemdRun := []string{"systemd-run", "--quiet", "--pipe",
-		fmt.Sprintf("--machine=%s", ct.MachineName(usrdata)),
-		fmt.Sprintf("--unit=%s", serviceName)}
-	properties := []struct{ name, value string }{}
+	properties := []systemd1.Property{
+		systemd1.PropDescription("nsbox session"),
+		// With exec, the job fails if the command can't be started at all.
+		systemd1.PropType("exec"),
+		systemd1.PropExecStart(spec.buildNsboxHostCommand(), false),
+		{Name: "Environment", Value: godbus.MakeVariant([]string{"NSBOX_INTERNAL=1"})},
+		{Name: "StandardInputFileDescriptor", Value: godbus.MakeVariant(godbus.UnixFD(os.Stdin.Fd()))},
+		{Name: "StandardOutputFileDescriptor", Value: godbus.MakeVariant(godbus.UnixFD(os.Stdout.Fd()))},
+		{Name: "StandardErrorFileDescriptor", Value: godbus.MakeVariant(godbus.UnixFD(os.Stderr.Fd()))},
+		// Keep the unit around after it exits until this connection is gone, so the exit
+		// status can still be read, regardless of whether or not it failed.
+		{Name: "AddRef", Value: godbus.MakeVariant(true)},
+		{Name: "CollectMode", Value: godbus.MakeVariant("inactive-or-failed")},
+	}
 
 	if selinux.Enabled() {
 		currentLabel, err := selinux.GetCurrentLabel()
@@ -165,33 +213,27 @@ func (door *systemdDoor) Enter(ct *container.Container, spec *containerEntrySpec
 			return nil, errors.Wrap(err, "find new selinux label")
 		}
 
-		properties = append(properties, property{
-			name:  "SELinuxContext",
-			value: newLabel,
+		properties = append(properties, systemd1.Property{
+			Name:  "SELinuxContext",
+			Value: godbus.MakeVariant(newLabel),
 		})
 	}
 
-	for _, prop := range properties {
-		systemdRun = append(systemdRun, fmt.Sprintf("--property=%s=%s", prop.name, prop.value))
-	}
-
-	systemdRun = append(systemdRun, "--setenv=NSBOX_INTERNAL=1", "--")
-	systemdRun = append(systemdRun, spec.buildNsboxHostCommand()...)
+	log.Debug("Starting transient unit in container:", serviceName, spec.buildNsboxHostCommand())
 
-	log.Debug("Starting transient unit in container:", systemdRun)
-
-	cmd := exec.Command(systemdRun[0], systemdRun[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	jobResult := make(chan string, 1)
+	if _, err := systemd.StartTransientUnit(serviceName, "fail", properties, jobResult); err != nil {
+		return nil, errors.Wrap(err, "start transient unit")
+	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, errors.Wrap(err, "starting systemd-run")
+	if result := <-jobResult; result != "done" {
+		return nil, errors.Errorf("starting %s: job %s", serviceName, result)
 	}
 
 	handle = &systemdSessionHandle{
-		process:     cmd.Process,
 		systemd:     systemd,
-		machineName: ct.MachineName(usrdata),
-		serviceName: serviceName}
+		serviceName: serviceName,
+		updates:     updates,
+	}
 	return handle, nil
 }
@@ -1,3 +0,0 @@
-#pragma once
-
-char *nsbox_forward_pty(int dstfd, int srcfd);
@@ -0,0 +1,266 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+	"golang.org/x/sys/unix"
+)
+
+// The header of an asciicast v2 file: https://docs.asciinema.org/manual/asciicast/v2/
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Writes a session's terminal output and resizes to an asciicast v2 file. Input isn't recorded,
+// so anything typed without being echoed (e.g. passwords) doesn't end up in the recording.
+type recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	started time.Time
+	// Output that ends partway through a UTF-8 sequence, which has to wait for the rest of it,
+	// since asciicast events are JSON strings.
+	pending []byte
+}
+
+func recordingsDir(ct *container.Container) string {
+	return filepath.Join(ct.Path, "recordings")
+}
+
+// Returns the path to record a new session to under the container's recordings directory,
+// creating it if needed.
+func newRecordingPath(ct *container.Container) (string, error) {
+	dir := recordingsDir(ct)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create recordings directory")
+	}
+
+	name := fmt.Sprintf("%s-%d.cast", time.Now().Format("20060102-150405"), os.Getpid())
+	return filepath.Join(dir, name), nil
+}
+
+// Sets the calling thread's filesystem uid, verifying that it took effect, since setfsuid
+// doesn't report failures.
+func setfsuid(uid int) error {
+	unix.Setfsuid(uid)
+	if current, _ := unix.SetfsuidRetUid(-1); current != uid {
+		return errors.Errorf("failed to set fsuid to %d", uid)
+	}
+
+	return nil
+}
+
+func setfsgid(gid int) error {
+	unix.Setfsgid(gid)
+	if current, _ := unix.SetfsgidRetGid(-1); current != gid {
+		return errors.Errorf("failed to set fsgid to %d", gid)
+	}
+
+	return nil
+}
+
+// Runs open with the filesystem credentials of the user, so it can only touch the files the
+// user could. Credentials are per-thread on Linux, so the rest of nsbox keeps running as root.
+func openAsUser(usrdata *userdata.Userdata, open func() (*os.File, error)) (*os.File, error) {
+	uid, gid := usrdata.NumericIds()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	groups, err := unix.Getgroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get groups")
+	}
+
+	// If any of these can't be undone, the thread would go back to the runtime with the
+	// wrong credentials, so bail out entirely.
+	restore := func(what string, undo func() error) {
+		if err := undo(); err != nil {
+			log.Fatalf("failed to restore %s: %v", what, err)
+		}
+	}
+
+	if err := unix.Setgroups([]int{gid}); err != nil {
+		return nil, errors.Wrap(err, "failed to set groups")
+	}
+
+	defer restore("groups", func() error { return unix.Setgroups(groups) })
+
+	if err := setfsgid(gid); err != nil {
+		return nil, err
+	}
+
+	defer restore("fsgid", func() error { return setfsgid(0) })
+
+	if err := setfsuid(uid); err != nil {
+		return nil, err
+	}
+
+	defer restore("fsuid", func() error { return setfsuid(0) })
+
+	return open()
+}
+
+// Creates a new recording file. If userPath is set, the path came from the user and is
+// created as them, otherwise it's one of the container's own recordings and is created as
+// root, then given to the user. Either way, existing files and symlinks are never written to.
+func createRecordingFile(path string, userPath bool, usrdata *userdata.Userdata) (*os.File, error) {
+	open := func() (*os.File, error) {
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0600)
+	}
+
+	var file *os.File
+	var err error
+	if userPath && os.Geteuid() == 0 {
+		file, err = openAsUser(usrdata, open)
+	} else {
+		file, err = open()
+	}
+
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errors.Errorf("recording %s already exists", path)
+		}
+
+		return nil, errors.Wrap(err, "failed to create recording")
+	}
+
+	if !userPath {
+		// The recording belongs to the user, not to whoever nsbox is running as.
+		uid, gid := usrdata.NumericIds()
+		if err := file.Chown(uid, gid); err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to change recording owner")
+		}
+	}
+
+	return file, nil
+}
+
+func newRecorder(path string, userPath bool, usrdata *userdata.Userdata, command []string, width, height int) (*recorder, error) {
+	file, err := createRecordingFile(path, userPath, usrdata)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{file: file, writer: bufio.NewWriter(file), started: time.Now()}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.started.Unix(),
+		Title:     strings.Join(command, " "),
+		Env:       map[string]string{},
+	}
+
+	for _, name := range []string{"SHELL", "TERM"} {
+		if value, ok := usrdata.Environ[name]; ok {
+			header.Env[name] = value
+		}
+	}
+
+	if err := rec.writeLine(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func (rec *recorder) writeLine(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal recording event")
+	}
+
+	if _, err := rec.writer.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write recording")
+	}
+
+	return nil
+}
+
+func (rec *recorder) writeEventLocked(code, data string) {
+	if rec.file == nil {
+		return
+	}
+
+	elapsed := time.Since(rec.started).Seconds()
+	if err := rec.writeLine([]interface{}{elapsed, code, data}); err != nil {
+		log.Debug(err)
+	}
+}
+
+func (rec *recorder) output(data []byte) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	data = append(rec.pending, data...)
+
+	// Hold back an incomplete sequence at the end, which is at most 3 bytes.
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+
+			break
+		}
+	}
+
+	rec.pending = append([]byte{}, data[complete:]...)
+	if complete != 0 {
+		rec.writeEventLocked("o", string(data[:complete]))
+	}
+}
+
+func (rec *recorder) resize(width, height int) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	rec.writeEventLocked("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+func (rec *recorder) Close() error {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if len(rec.pending) != 0 {
+		rec.writeEventLocked("o", string(rec.pending))
+		rec.pending = nil
+	}
+
+	file := rec.file
+	rec.file = nil
+
+	if err := rec.writer.Flush(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to flush recording")
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/log"
+)
+
+type Recording struct {
+	Path     string
+	Recorded time.Time
+	Size     int64
+	Command  string
+}
+
+func readRecording(path string, info os.FileInfo) *Recording {
+	recording := &Recording{Path: path, Recorded: info.ModTime(), Size: info.Size()}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Debugf("failed to open recording %s: %v", path, err)
+		return recording
+	}
+
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		log.Debugf("failed to read recording header of %s: %v", path, err)
+		return recording
+	}
+
+	var header asciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		log.Debugf("failed to parse recording header of %s: %v", path, err)
+		return recording
+	}
+
+	recording.Recorded = time.Unix(header.Timestamp, 0)
+	recording.Command = header.Title
+	return recording
+}
+
+// Returns the container's recordings, newest first.
+func ListRecordings(ct *container.Container) ([]*Recording, error) {
+	dir := recordingsDir(ct)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read recordings directory")
+	}
+
+	recordings := []*Recording{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".cast") {
+			continue
+		}
+
+		recordings = append(recordings, readRecording(filepath.Join(dir, entry.Name()), entry))
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Recorded.After(recordings[j].Recorded)
+	})
+
+	return recordings, nil
+}
+
+func ShowRecordings(ct *container.Container) error {
+	recordings, err := ListRecordings(ct)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "RECORDED\tSIZE\tCOMMAND\tPATH")
+
+	for _, recording := range recordings {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", humanize.Time(recording.Recorded),
+			humanize.Bytes(uint64(recording.Size)), recording.Command, recording.Path)
+	}
+
+	return nil
+}
+
+// Deletes the container's recordings that are older than olderThan (if non-zero), or that
+// aren't among the newest keep recordings (if keep is non-negative).
+func PruneRecordings(ct *container.Container, olderThan time.Duration, keep int) error {
+	recordings, err := ListRecordings(ct)
+	if err != nil {
+		return err
+	}
+
+	for i, recording := range recordings {
+		tooOld := olderThan != 0 && time.Since(recording.Recorded) > olderThan
+		tooMany := keep >= 0 && i >= keep
+		if !tooOld && !tooMany {
+			continue
+		}
+
+		log.Debug("removing recording", recording.Path)
+
+		if err := os.Remove(recording.Path); err != nil {
+			return errors.Wrapf(err, "failed to remove recording %s", recording.Path)
+		}
+	}
+
+	return nil
+}
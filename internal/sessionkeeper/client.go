@@ -0,0 +1,206 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package sessionkeeper
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// Typing this (Ctrl-]) while attached detaches from the session.
+const DetachKey = 0x1d
+
+type CreateOptions struct {
+	Name     string
+	Uid      int
+	Cwd      string
+	NoReplay bool
+	Env      []string
+	Command  []string
+}
+
+func call(socketPath string, req *request) (net.Conn, *reply, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to connect to session keeper")
+	}
+
+	if err := writeJsonFrame(conn, frameRequest, req); err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrap(err, "failed to send session keeper request")
+	}
+
+	var rep reply
+	if err := readJsonFrame(conn, frameReply, &rep); err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrap(err, "failed to read session keeper reply")
+	}
+
+	if rep.Error != "" {
+		conn.Close()
+		return nil, nil, errors.New(rep.Error)
+	}
+
+	return conn, &rep, nil
+}
+
+func terminalSize(file *os.File) (uint16, uint16, bool) {
+	winsize, err := unix.IoctlGetWinsize(int(file.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return winsize.Row, winsize.Col, true
+}
+
+// Starts a new detached session, returning its name.
+func Create(socketPath string, options CreateOptions) (string, error) {
+	req := &request{
+		Op:       opCreate,
+		Name:     options.Name,
+		Uid:      options.Uid,
+		Cwd:      options.Cwd,
+		NoReplay: options.NoReplay,
+		Env:      options.Env,
+		Command:  options.Command,
+	}
+
+	if rows, cols, ok := terminalSize(os.Stdout); ok {
+		req.Rows, req.Cols = rows, cols
+	}
+
+	conn, rep, err := call(socketPath, req)
+	if err != nil {
+		return "", err
+	}
+
+	conn.Close()
+	return rep.Name, nil
+}
+
+func List(socketPath string) ([]SessionInfo, error) {
+	conn, rep, err := call(socketPath, &request{Op: opList})
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Close()
+	return rep.Sessions, nil
+}
+
+// Attaches the terminal to the session, replaying its scrollback, until either it exits or the
+// user detaches. If it exited, its exit status is returned, otherwise detached is true.
+func Attach(socketPath, name string) (status int, detached bool, err error) {
+	conn, _, err := call(socketPath, &request{Op: opAttach, Name: name})
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer conn.Close()
+
+	stdinFd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(stdinFd) {
+		oldState, err := terminal.MakeRaw(stdinFd)
+		if err != nil {
+			log.Debug("failed to make terminal raw", err)
+		} else {
+			defer terminal.Restore(stdinFd, oldState)
+		}
+	}
+
+	sendSize := func() {
+		if rows, cols, ok := terminalSize(os.Stdout); ok {
+			if err := writeFrame(conn, frameResize, encodeResize(rows, cols)); err != nil {
+				log.Debug("failed to send window size:", err)
+			}
+		}
+	}
+
+	sendSize()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, unix.SIGWINCH)
+	defer signal.Stop(sigchan)
+
+	go func() {
+		for range sigchan {
+			sendSize()
+		}
+	}()
+
+	detach := make(chan struct{})
+
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if n > 0 {
+				data := buffer[:n]
+				if index := bytes.IndexByte(data, DetachKey); index != -1 {
+					if index > 0 {
+						writeFrame(conn, frameData, data[:index])
+					}
+
+					close(detach)
+					return
+				}
+
+				if err := writeFrame(conn, frameData, data); err != nil {
+					return
+				}
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	type result struct {
+		status int
+		err    error
+	}
+
+	results := make(chan result, 1)
+
+	go func() {
+		for {
+			typ, payload, err := readFrame(conn)
+			if err != nil {
+				results <- result{err: errors.Wrap(err, "lost connection to session keeper")}
+				return
+			}
+
+			switch typ {
+			case frameData:
+				if _, err := os.Stdout.Write(payload); err != nil {
+					results <- result{err: errors.Wrap(err, "failed to write output")}
+					return
+				}
+			case frameExit:
+				status, err := decodeExit(payload)
+				results <- result{status: status, err: err}
+				return
+			default:
+				results <- result{err: errors.Errorf("unexpected frame type %d", typ)}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-detach:
+		return 0, true, nil
+	case res := <-results:
+		return res.status, false, res.err
+	}
+}
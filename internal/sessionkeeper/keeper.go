@@ -0,0 +1,369 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// The session keeper runs inside the container next to the PTY service, and holds onto the PTYs
+// of detached sessions, so they outlive the client that started them and can be reattached.
+package sessionkeeper
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/exitstatus"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
+	"golang.org/x/sys/unix"
+)
+
+// How much output is kept around to be replayed when attaching.
+const scrollbackSize = 64 * 1024
+
+var validSessionName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type session struct {
+	name    string
+	command []string
+	started time.Time
+	master  *os.File
+
+	mutex      sync.Mutex
+	scrollback []byte
+	clients    map[net.Conn]struct{}
+	exited     bool
+	exitStatus int
+}
+
+type keeper struct {
+	mutex    sync.Mutex
+	sessions map[string]*session
+	nextId   int
+}
+
+func (s *session) info() SessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return SessionInfo{
+		Name:       s.name,
+		Command:    s.command,
+		Started:    s.started,
+		Running:    !s.exited,
+		ExitStatus: s.exitStatus,
+		Clients:    len(s.clients),
+	}
+}
+
+// Sends the data to every attached client, dropping any that can't be written to.
+func (s *session) broadcastLocked(typ frameType, payload []byte) {
+	for client := range s.clients {
+		if err := writeFrame(client, typ, payload); err != nil {
+			log.Debugf("dropping client of session %s: %v", s.name, err)
+			client.Close()
+			delete(s.clients, client)
+		}
+	}
+}
+
+func (s *session) appendScrollbackLocked(data []byte) {
+	s.scrollback = append(s.scrollback, data...)
+	if excess := len(s.scrollback) - scrollbackSize; excess > 0 {
+		s.scrollback = s.scrollback[excess:]
+	}
+}
+
+func (s *session) forwardOutput() {
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := s.master.Read(buffer)
+		if n > 0 {
+			s.mutex.Lock()
+			s.appendScrollbackLocked(buffer[:n])
+			s.broadcastLocked(frameData, buffer[:n])
+			s.mutex.Unlock()
+		}
+
+		if err != nil {
+			// Once every slave FD is closed, reads fail with EIO.
+			return
+		}
+	}
+}
+
+func (k *keeper) createSession(req *request) (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	name := req.Name
+	if name == "" {
+		for {
+			k.nextId++
+			name = strconv.Itoa(k.nextId)
+			if _, exists := k.sessions[name]; !exists {
+				break
+			}
+		}
+	} else if !validSessionName.MatchString(name) {
+		return "", errors.Errorf("invalid session name: %s", name)
+	} else if _, exists := k.sessions[name]; exists {
+		return "", errors.Errorf("session %s already exists", name)
+	}
+
+	if len(req.Command) == 0 {
+		return "", errors.New("expected a command")
+	}
+
+	master, slave, err := pty.Open()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open pty")
+	}
+
+	if req.Rows != 0 && req.Cols != 0 {
+		if err := pty.Setsize(master, &pty.Winsize{Rows: req.Rows, Cols: req.Cols}); err != nil {
+			log.Debug("failed to set initial session size:", err)
+		}
+	}
+
+	slavePath := slave.Name()
+
+	cmdline := []string{"enter", fmt.Sprintf("-uid=%d", req.Uid), fmt.Sprintf("-cwd=%s", req.Cwd)}
+	if req.NoReplay {
+		cmdline = append(cmdline, "-no-replay")
+	}
+
+	for _, stream := range []string{"stdin", "stdout", "stderr"} {
+		cmdline = append(cmdline, fmt.Sprintf("-%s=%s", stream, slavePath))
+	}
+
+	cmdline = append(cmdline, "env")
+	cmdline = append(cmdline, req.Env...)
+	cmdline = append(cmdline, fmt.Sprintf("%s=%s", container.SessionEnvVar, name))
+	cmdline = append(cmdline, req.Command...)
+
+	cmd := exec.Command("/run/host/nsbox/bin/nsbox-host", cmdline...)
+	cmd.Env = append(os.Environ(), "NSBOX_INTERNAL=1")
+
+	log.Debug("starting session:", cmd.Args)
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		slave.Close()
+		return "", errors.Wrap(err, "failed to start session")
+	}
+
+	s := &session{
+		name:    name,
+		command: req.Command,
+		started: time.Now(),
+		master:  master,
+		clients: map[net.Conn]struct{}{},
+	}
+
+	k.sessions[name] = s
+
+	outputDone := make(chan struct{})
+	go func() {
+		s.forwardOutput()
+		close(outputDone)
+	}()
+
+	go func() {
+		// The slave is held open until the session exits, so the output forwarding doesn't see
+		// EIO before the session had a chance to open it.
+		status := exitstatus.FromWaitError(cmd.Wait())
+		slave.Close()
+		<-outputDone
+		master.Close()
+
+		log.Debugf("session %s exited with status %d", name, status)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.exited = true
+		s.exitStatus = status
+
+		if len(s.clients) == 0 {
+			// Keep it around, so the final output can still be seen by attaching.
+			return
+		}
+
+		s.broadcastLocked(frameExit, encodeExit(status))
+		for client := range s.clients {
+			client.Close()
+		}
+
+		s.clients = nil
+		k.removeSession(s)
+	}()
+
+	return name, nil
+}
+
+func (k *keeper) removeSession(s *session) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.sessions[s.name] == s {
+		delete(k.sessions, s.name)
+	}
+}
+
+func (k *keeper) listSessions() []SessionInfo {
+	k.mutex.Lock()
+	sessions := []*session{}
+	for _, s := range k.sessions {
+		sessions = append(sessions, s)
+	}
+	k.mutex.Unlock()
+
+	infos := []SessionInfo{}
+	for _, s := range sessions {
+		infos = append(infos, s.info())
+	}
+
+	return infos
+}
+
+func (k *keeper) attachSession(conn net.Conn, name string) error {
+	k.mutex.Lock()
+	s, ok := k.sessions[name]
+	k.mutex.Unlock()
+
+	if !ok {
+		return writeJsonFrame(conn, frameReply, reply{Error: fmt.Sprintf("session %s does not exist", name)})
+	}
+
+	s.mutex.Lock()
+
+	if err := writeJsonFrame(conn, frameReply, reply{Name: name}); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+
+	if len(s.scrollback) != 0 {
+		if err := writeFrame(conn, frameData, s.scrollback); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+	}
+
+	if s.exited {
+		// Nobody saw it exit, so let this client know and then drop the session.
+		err := writeFrame(conn, frameExit, encodeExit(s.exitStatus))
+		s.mutex.Unlock()
+
+		k.removeSession(s)
+		return err
+	}
+
+	s.clients[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, conn)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		typ, payload, err := readFrame(conn)
+		if err != nil {
+			// The client detached or went away.
+			return nil
+		}
+
+		switch typ {
+		case frameData:
+			if _, err := s.master.Write(payload); err != nil {
+				log.Debugf("failed to write to session %s: %v", name, err)
+			}
+		case frameResize:
+			rows, cols, err := decodeResize(payload)
+			if err != nil {
+				return err
+			}
+
+			if err := pty.Setsize(s.master, &pty.Winsize{Rows: rows, Cols: cols}); err != nil {
+				log.Debugf("failed to resize session %s: %v", name, err)
+			}
+		default:
+			return errors.Errorf("unexpected frame type %d", typ)
+		}
+	}
+}
+
+func (k *keeper) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	var req request
+	if err := readJsonFrame(conn, frameRequest, &req); err != nil {
+		log.Alert("failed to read session keeper request:", err)
+		return
+	}
+
+	var err error
+
+	switch req.Op {
+	case opCreate:
+		name, createErr := k.createSession(&req)
+		if createErr != nil {
+			err = writeJsonFrame(conn, frameReply, reply{Error: createErr.Error()})
+		} else {
+			err = writeJsonFrame(conn, frameReply, reply{Name: name})
+		}
+	case opList:
+		err = writeJsonFrame(conn, frameReply, reply{Sessions: k.listSessions()})
+	case opAttach:
+		err = k.attachSession(conn, req.Name)
+	default:
+		err = writeJsonFrame(conn, frameReply, reply{Error: fmt.Sprintf("unknown operation %s", req.Op)})
+	}
+
+	if err != nil {
+		log.Alert("failed to handle session keeper request:", err)
+	}
+}
+
+func StartSessionKeeper() error {
+	socketPath := "/run/host/nsbox/" + paths.SessionKeeperSocketName
+
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove old session keeper socket")
+	}
+
+	// Anyone who can connect can start sessions as any user, so only root may do so.
+	oldUmask := unix.Umask(0077)
+	listener, err := net.Listen("unix", socketPath)
+	unix.Umask(oldUmask)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to listen on session keeper socket")
+	}
+
+	k := &keeper{sessions: map[string]*session{}}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Alert("failed to accept session keeper connection:", err)
+				return
+			}
+
+			go k.handleConnection(conn)
+		}
+	}()
+
+	return nil
+}
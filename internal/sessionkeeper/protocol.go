@@ -0,0 +1,156 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package sessionkeeper
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Everything sent over the keeper's socket is split into frames, made up of a one-byte type,
+// a four-byte length, and the payload.
+type frameType byte
+
+const (
+	// A JSON request, sent by the client as the first frame.
+	frameRequest frameType = iota
+	// A JSON reply to a request.
+	frameReply
+	// Output from or input to the session's terminal.
+	frameData
+	// The client's window size, as two 16-bit integers (rows, then columns).
+	frameResize
+	// The session exited, with its exit status as a 32-bit integer.
+	frameExit
+)
+
+const (
+	frameHeaderSize = 5
+	maxFrameSize    = 64 * 1024
+)
+
+const (
+	opCreate = "create"
+	opAttach = "attach"
+	opList   = "list"
+)
+
+type request struct {
+	Op   string
+	Name string
+
+	// Only used for opCreate.
+	Uid      int
+	Cwd      string
+	NoReplay bool
+	Env      []string
+	Command  []string
+	Rows     uint16
+	Cols     uint16
+}
+
+type reply struct {
+	Error    string        `json:",omitempty"`
+	Name     string        `json:",omitempty"`
+	Sessions []SessionInfo `json:",omitempty"`
+}
+
+type SessionInfo struct {
+	Name       string
+	Command    []string
+	Started    time.Time
+	Running    bool
+	ExitStatus int
+	// The number of clients currently attached.
+	Clients int
+}
+
+func writeFrame(w io.Writer, typ frameType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return errors.Errorf("frame of size %d is too large", len(payload))
+	}
+
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = byte(typ)
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (frameType, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, errors.Errorf("frame of size %d is too large", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return frameType(header[0]), payload, nil
+}
+
+func writeJsonFrame(w io.Writer, typ frameType, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "marshalling message")
+	}
+
+	return writeFrame(w, typ, data)
+}
+
+func readJsonFrame(r io.Reader, expected frameType, value interface{}) error {
+	typ, payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+
+	if typ != expected {
+		return errors.Errorf("expected frame type %d, got %d", expected, typ)
+	}
+
+	return errors.Wrap(json.Unmarshal(payload, value), "unmarshalling message")
+}
+
+func encodeResize(rows, cols uint16) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[:2], rows)
+	binary.BigEndian.PutUint16(payload[2:], cols)
+	return payload
+}
+
+func decodeResize(payload []byte) (uint16, uint16, error) {
+	if len(payload) != 4 {
+		return 0, 0, errors.New("invalid resize frame")
+	}
+
+	return binary.BigEndian.Uint16(payload[:2]), binary.BigEndian.Uint16(payload[2:]), nil
+}
+
+func encodeExit(status int) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(int32(status)))
+	return payload
+}
+
+func decodeExit(payload []byte) (int, error) {
+	if len(payload) != 4 {
+		return 0, errors.New("invalid exit frame")
+	}
+
+	return int(int32(binary.BigEndian.Uint32(payload))), nil
+}
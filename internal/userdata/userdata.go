@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/config"
@@ -190,6 +191,26 @@ func BeneathSudo() (*Userdata, error) {
 	return userdataForUser(usr)
 }
 
+// Returns the userdata for the given user, for services acting on behalf of a user that didn't
+// run them. There's no session to take the environment from, so only XDG_RUNTIME_DIR is set.
+func ForUid(uid int) (*Userdata, error) {
+	usr, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, err
+	}
+
+	usrdata, err := userdataForUser(usr)
+	if err != nil {
+		return nil, err
+	}
+
+	usrdata.Environ = map[string]string{
+		"XDG_RUNTIME_DIR": fmt.Sprintf("/run/user/%d", uid),
+	}
+
+	return usrdata, nil
+}
+
 func (usrdata *Userdata) ShadowLine() (string, error) {
 	out, err := getent("shadow", usrdata.User)
 	if err != nil {
@@ -236,3 +257,34 @@ func (usrdata Userdata) NumericIds() (uid int, gid int) {
 
 	return
 }
+
+// The PATH that commands run on the host on the user's behalf get, rather than nsboxd's own.
+const DefaultHostPath = "/usr/local/bin:/usr/bin:/bin:/usr/local/sbin:/usr/sbin:/sbin"
+
+// Returns the credentials to run commands on the host as the user.
+func (usrdata Userdata) Credential() *syscall.Credential {
+	uid, gid := usrdata.NumericIds()
+
+	groups := []uint32{}
+	for _, group := range usrdata.Groups {
+		if id, err := strconv.ParseUint(group.Gid, 10, 32); err == nil {
+			groups = append(groups, uint32(id))
+		}
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+}
+
+// Returns the minimal environment for commands run on the host as the user.
+func (usrdata Userdata) HostEnviron() []string {
+	uid, _ := usrdata.NumericIds()
+
+	return []string{
+		"HOME=" + usrdata.User.HomeDir,
+		"USER=" + usrdata.User.Username,
+		"LOGNAME=" + usrdata.User.Username,
+		"SHELL=" + usrdata.Shell,
+		"PATH=" + DefaultHostPath,
+		fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", uid),
+	}
+}
@@ -0,0 +1,30 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package devnsbox
+
+// The version of the dev.nsbox protocol spoken by this build. Version 1 predates Handshake, so
+// a host that doesn't have it is assumed to be at version 1, with no capabilities.
+const ProtocolVersion = 2
+
+// The optional features a host can report from Handshake. A client should check for these
+// before calling the corresponding methods, since the host may be running an older nsbox.
+const (
+	// NotifyStartFailed
+	CapabilityStartFailed = "start-failed"
+	// Spawn and SignalSpawned
+	CapabilitySpawn = "spawn"
+	// OpenURI
+	CapabilityOpenURI = "open-uri"
+	// GetInfo and GetExports
+	CapabilityInfo = "info"
+)
+
+// Every capability supported by this build.
+var Capabilities = []string{
+	CapabilityStartFailed,
+	CapabilitySpawn,
+	CapabilityOpenURI,
+	CapabilityInfo,
+}
@@ -0,0 +1,223 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package devnsbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/varlink/go/varlink"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// A host that gives a fixed reply to each method, so the exchanges are always the same.
+type testHost struct {
+	VarlinkInterface
+}
+
+func (host *testHost) Handshake(ctx context.Context, call VarlinkCall, clientProtocol int64) error {
+	return call.ReplyHandshake(ctx, ProtocolVersion, Capabilities)
+}
+
+func (host *testHost) Spawn(ctx context.Context, call VarlinkCall, command []string, environ []string, cwd string, tty bool) error {
+	return call.ReplySpawnFailed(ctx, "expected a command")
+}
+
+func (host *testHost) OpenURI(ctx context.Context, call VarlinkCall, uri string) error {
+	return call.ReplyInvalidURI(ctx, uri, "unsupported scheme")
+}
+
+func (host *testHost) GetInfo(ctx context.Context, call VarlinkCall) error {
+	return call.ReplyGetInfo(ctx, testInfo)
+}
+
+func (host *testHost) GetExports(ctx context.Context, call VarlinkCall) error {
+	return call.ReplyGetExports(ctx, testDesktopFiles, testBinaries)
+}
+
+var (
+	testInfo = Info{
+		Name:        "test",
+		Image_chain: []string{"custom", "fedora:32"},
+		Booted:      true,
+		Config:      json.RawMessage(`{"boot":true}`),
+		Version:     "23.04",
+	}
+
+	testDesktopFiles = []string{"virt-manager.desktop"}
+	testBinaries     = []string{"code", "virt-manager"}
+)
+
+// Collects the replies the service writes for a single message.
+type replyBuffer struct {
+	bytes.Buffer
+}
+
+func (buffer *replyBuffer) Write(ctx context.Context, data []byte) (int, error) {
+	return buffer.Buffer.Write(data)
+}
+
+func (buffer *replyBuffer) Read(ctx context.Context, data []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (buffer *replyBuffer) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	return nil, io.EOF
+}
+
+// Serves the test host on a socket, writing every message to transcript as it goes.
+func serveTestHost(t *testing.T, socket string, transcript *bytes.Buffer, mutex *sync.Mutex) {
+	service, err := varlink.NewService("nsbox", "nsbox", "1", "https://nsbox.dev/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.RegisterInterface(VarlinkNew(&testHost{})); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			request, err := reader.ReadBytes(0)
+			if err != nil {
+				return
+			}
+
+			var reply replyBuffer
+			if err := service.HandleMessage(context.Background(), &reply, request[:len(request)-1]); err != nil {
+				return
+			}
+
+			mutex.Lock()
+			transcript.WriteString("-> " + string(bytes.TrimSuffix(request, []byte{0})) + "\n")
+			for _, message := range bytes.Split(bytes.TrimSuffix(reply.Bytes(), []byte{0}), []byte{0}) {
+				transcript.WriteString("<- " + string(message) + "\n")
+			}
+			mutex.Unlock()
+
+			if _, err := conn.Write(reply.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Checks the exact messages sent by the generated client and service bindings against
+// testdata/dev.nsbox.golden, so changes to the protocol's wire format can't happen by accident.
+// Run with -update to regenerate it after an intentional change.
+func TestProtocolGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsbox-varlink-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var transcript bytes.Buffer
+	var mutex sync.Mutex
+
+	socket := filepath.Join(dir, "socket")
+	serveTestHost(t, socket, &transcript, &mutex)
+
+	ctx := context.Background()
+
+	conn, err := varlink.NewConnection(ctx, "unix:"+socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if version, capabilities, err := Handshake().Call(ctx, conn, ProtocolVersion); err != nil {
+		t.Errorf("Handshake: %v", err)
+	} else if version != ProtocolVersion || !reflect.DeepEqual(capabilities, Capabilities) {
+		t.Errorf("Handshake: got protocol %d with %v", version, capabilities)
+	}
+
+	if info, err := GetInfo().Call(ctx, conn); err != nil {
+		t.Errorf("GetInfo: %v", err)
+	} else if !reflect.DeepEqual(info, testInfo) {
+		t.Errorf("GetInfo: expected %+v, got %+v", testInfo, info)
+	}
+
+	if desktopFiles, binaries, err := GetExports().Call(ctx, conn); err != nil {
+		t.Errorf("GetExports: %v", err)
+	} else if !reflect.DeepEqual(desktopFiles, testDesktopFiles) || !reflect.DeepEqual(binaries, testBinaries) {
+		t.Errorf("GetExports: expected %v and %v, got %v and %v", testDesktopFiles, testBinaries,
+			desktopFiles, binaries)
+	}
+
+	if _, _, err := Spawn().Call(ctx, conn, []string{}, []string{"TERM=xterm"}, "/", false); err == nil {
+		t.Error("Spawn: expected an error")
+	} else if failed, ok := err.(*SpawnFailed); !ok || failed.Reason != "expected a command" {
+		t.Errorf("Spawn: unexpected error %#v", err)
+	}
+
+	if err := OpenURI().Call(ctx, conn, "gopher://example.com"); err == nil {
+		t.Error("OpenURI: expected an error")
+	} else if invalid, ok := err.(*InvalidURI); !ok || invalid.Uri != "gopher://example.com" {
+		t.Errorf("OpenURI: unexpected error %#v", err)
+	}
+
+	// Methods the host doesn't know about, which is how a host from before Handshake (or any
+	// other method) was added replies.
+	if err := conn.Call(ctx, "dev.nsbox.NotAMethod", nil, nil); err == nil {
+		t.Error("NotAMethod: expected an error")
+	} else if notFound, ok := err.(*varlink.MethodNotFound); !ok || notFound.Method != "NotAMethod" {
+		t.Errorf("NotAMethod: unexpected error %#v", err)
+	}
+
+	conn.Close()
+
+	mutex.Lock()
+	got := transcript.Bytes()
+	mutex.Unlock()
+
+	golden := filepath.Join("testdata", "dev.nsbox.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, expected) {
+		t.Errorf("protocol messages differ from %s (run with -update if this is intended):\n%s\nexpected:\n%s",
+			golden, got, expected)
+	}
+}
@@ -13,30 +13,150 @@ package devnsbox
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/varlink/go/varlink"
 )
 
+type Info struct {
+	Name        string          `json:"name"`
+	Image_chain []string        `json:"image_chain"`
+	Booted      bool            `json:"booted"`
+	Config      json.RawMessage `json:"config"`
+	Version     string          `json:"version"`
+}
+
+type SpawnDisabled struct{}
+
+func (e SpawnDisabled) Error() string
+
+type SpawnFailed struct {
+	Reason string
+}
+
+func (e SpawnFailed) Error() string
+
+type InvalidURI struct {
+	Uri    string
+	Reason string
+}
+
+func (e InvalidURI) Error() string
+
+type OpenFailed struct {
+	Reason string
+}
+
+func (e OpenFailed) Error() string
+
+func Dispatch_Error(err error) error
+
+type Handshake_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, client_protocol_ int64) (protocol_ int64, capabilities_ []string, err_ error)
+}
+
+func Handshake() Handshake_methods
+
 type NotifyStart_methods interface {
 	Call(ctx context.Context, c *varlink.Connection) error
 }
 
 func NotifyStart() NotifyStart_methods
 
+type NotifyStartFailed_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, stage_ string, message_ string, log_ string) error
+}
+
+func NotifyStartFailed() NotifyStartFailed_methods
+
 type NotifyReloadExports_methods interface {
 	Call(ctx context.Context, c *varlink.Connection) error
 }
 
 func NotifyReloadExports() NotifyReloadExports_methods
 
-type VarlinkCall interface {
-	ReplyNotifyStart(ctx context.Context) error
-	ReplyNotifyReloadExports(ctx context.Context) error
+type NotifyHookFailed_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, hook_ string, path_ string, status_ int64) error
+}
+
+func NotifyHookFailed() NotifyHookFailed_methods
+
+type NotifySessionEnter_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, pid_ int64) error
+}
+
+func NotifySessionEnter() NotifySessionEnter_methods
+
+type NotifySessionExit_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, pid_ int64) error
+}
+
+func NotifySessionExit() NotifySessionExit_methods
+
+type GetSessions_methods interface {
+	Call(ctx context.Context, c *varlink.Connection) (count_ int64, idle_seconds_ int64, err_ error)
+}
+
+func GetSessions() GetSessions_methods
+
+type SignalSpawned_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, id_ int64, signal_ int64) error
+}
+
+func SignalSpawned() SignalSpawned_methods
+
+type OpenURI_methods interface {
+	Call(ctx context.Context, c *varlink.Connection, uri_ string) error
+}
+
+func OpenURI() OpenURI_methods
+
+type GetInfo_methods interface {
+	Call(ctx context.Context, c *varlink.Connection) (info_ Info, err_ error)
 }
 
+func GetInfo() GetInfo_methods
+
+type GetExports_methods interface {
+	Call(ctx context.Context, c *varlink.Connection) (desktop_files_ []string, binaries_ []string, err_ error)
+}
+
+func GetExports() GetExports_methods
+
+type VarlinkCall struct{ varlink.Call }
+
+func (c *VarlinkCall) ReplySpawnDisabled(ctx context.Context) error
+func (c *VarlinkCall) ReplySpawnFailed(ctx context.Context, reason_ string) error
+func (c *VarlinkCall) ReplyInvalidURI(ctx context.Context, uri_ string, reason_ string) error
+func (c *VarlinkCall) ReplyOpenFailed(ctx context.Context, reason_ string) error
+func (c *VarlinkCall) ReplyHandshake(ctx context.Context, protocol_ int64, capabilities_ []string) error
+func (c *VarlinkCall) ReplyNotifyStart(ctx context.Context) error
+func (c *VarlinkCall) ReplyNotifyStartFailed(ctx context.Context) error
+func (c *VarlinkCall) ReplyNotifyReloadExports(ctx context.Context) error
+func (c *VarlinkCall) ReplyNotifyHookFailed(ctx context.Context) error
+func (c *VarlinkCall) ReplyNotifySessionEnter(ctx context.Context) error
+func (c *VarlinkCall) ReplyNotifySessionExit(ctx context.Context) error
+func (c *VarlinkCall) ReplyGetSessions(ctx context.Context, count_ int64, idle_seconds_ int64) error
+func (c *VarlinkCall) ReplySpawn(ctx context.Context, id_ int64, status_ int64) error
+func (c *VarlinkCall) ReplySignalSpawned(ctx context.Context) error
+func (c *VarlinkCall) ReplyOpenURI(ctx context.Context) error
+func (c *VarlinkCall) ReplyGetInfo(ctx context.Context, info_ Info) error
+func (c *VarlinkCall) ReplyGetExports(ctx context.Context, desktop_files_ []string, binaries_ []string) error
+
 type iface interface {
+	Handshake(ctx context.Context, c VarlinkCall, client_protocol_ int64) error
 	NotifyStart(ctx context.Context, c VarlinkCall) error
+	NotifyStartFailed(ctx context.Context, c VarlinkCall, stage_ string, message_ string, log_ string) error
 	NotifyReloadExports(ctx context.Context, c VarlinkCall) error
+	NotifyHookFailed(ctx context.Context, c VarlinkCall, hook_ string, path_ string, status_ int64) error
+	NotifySessionEnter(ctx context.Context, c VarlinkCall, pid_ int64) error
+	NotifySessionExit(ctx context.Context, c VarlinkCall, pid_ int64) error
+	GetSessions(ctx context.Context, c VarlinkCall) error
+	Spawn(ctx context.Context, c VarlinkCall, command_ []string, environ_ []string, cwd_ string, tty_ bool) error
+	SignalSpawned(ctx context.Context, c VarlinkCall, id_ int64, signal_ int64) error
+	OpenURI(ctx context.Context, c VarlinkCall, uri_ string) error
+	GetInfo(ctx context.Context, c VarlinkCall) error
+	GetExports(ctx context.Context, c VarlinkCall) error
 }
 
 type VarlinkInterface struct {
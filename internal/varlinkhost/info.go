@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/integration"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/release"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+)
+
+func (host *VarlinkHost) GetInfo(ctx context.Context, call devnsbox.VarlinkCall) error {
+	log.Debug("received GetInfo()")
+
+	// The config can include things like the host paths of exports and hooks, so other users in
+	// the container shouldn't be able to see it.
+	if err := host.requireOwnerOrRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	// Re-read the config, so changes made since the container started show up.
+	ct, err := container.OpenPath(host.container.Path, host.container.Name)
+	if err != nil {
+		return err
+	}
+
+	config, err := json.Marshal(ct.Config)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+
+	rel, err := release.Read()
+	if err != nil {
+		return errors.Wrap(err, "failed to read release info")
+	}
+
+	return call.ReplyGetInfo(ctx, devnsbox.Info{
+		Name: ct.Name,
+		// The chain the container was actually started with, which a changed config doesn't
+		// affect until it's restarted.
+		Image_chain: strings.Fields(host.usrdata.Environ["NSBOX_IMAGE_CHAIN"]),
+		Booted:      host.container.Config.Boot,
+		Config:      config,
+		Version:     rel.Version,
+	})
+}
+
+func (host *VarlinkHost) GetExports(ctx context.Context, call devnsbox.VarlinkCall) error {
+	log.Debug("received GetExports()")
+
+	desktopFiles, err := integration.ExportedDesktopFiles(host.container)
+	if err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	binaries, err := integration.ExportedBinaries(host.container)
+	if err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	return call.ReplyGetExports(ctx, desktopFiles, binaries)
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/refi64/nsbox/internal/log"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/refi64/nsbox/internal/varlinkpeer"
+	"golang.org/x/sys/unix"
+)
+
+// The URI schemes containers may open. Anything else could be handled by who knows what on
+// the host.
+var openableSchemes = map[string]bool{
+	"file":   true,
+	"ftp":    true,
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// Maps a file path inside the container to the host, preferring where it's shared from so the
+// host app sees the same file the container does.
+func (host *VarlinkHost) hostFilePath(path string) string {
+	path = filepath.Clean(path)
+
+	if hostPath := host.container.HostPath(host.usrdata, path); hostPath != "" {
+		return hostPath
+	}
+
+	return host.container.StorageChild(path)
+}
+
+func (host *VarlinkHost) OpenURI(ctx context.Context, call devnsbox.VarlinkCall, uri string) error {
+	log.Debugf("received OpenURI(%s)", uri)
+
+	peer, err := varlinkpeer.FromCall(call.Call)
+	if err != nil {
+		return err
+	}
+
+	if !host.isOwnerOrRoot(peer) {
+		return call.ReplyOpenFailed(ctx, "only the container's owner can open URIs on the host")
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return call.ReplyInvalidURI(ctx, uri, err.Error())
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if !openableSchemes[parsed.Scheme] {
+		return call.ReplyInvalidURI(ctx, uri, fmt.Sprintf("unsupported scheme: %q", parsed.Scheme))
+	}
+
+	if parsed.Scheme == "file" {
+		if parsed.Host != "" && parsed.Host != "localhost" {
+			return call.ReplyInvalidURI(ctx, uri, "remote files cannot be opened")
+		}
+
+		if !filepath.IsAbs(parsed.Path) {
+			return call.ReplyInvalidURI(ctx, uri, "file paths must be absolute")
+		}
+
+		hostPath := host.hostFilePath(parsed.Path)
+		if _, err := os.Stat(hostPath); err != nil {
+			return call.ReplyOpenFailed(ctx, fmt.Sprintf("%s: %v", parsed.Path, err))
+		}
+
+		log.Debugf("mapped %s to %s", parsed.Path, hostPath)
+		parsed = &url.URL{Scheme: "file", Path: hostPath}
+	}
+
+	environ := []string{}
+	for name, value := range host.usrdata.Environ {
+		environ = append(environ, name+"="+value)
+	}
+
+	credential := host.usrdata.Credential()
+
+	cmd := exec.Command("gio", "open", parsed.String())
+	cmd.Env = spawnEnviron(host.usrdata, environ)
+	if _, ok := host.usrdata.Environ["DBUS_SESSION_BUS_ADDRESS"]; !ok {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/%d/bus",
+			credential.Uid))
+	}
+
+	cmd.Dir = host.usrdata.User.HomeDir
+	// Apps that gio launches directly outlive it, so keep them out of nsboxd's session.
+	cmd.SysProcAttr = &unix.SysProcAttr{Credential: credential, Setsid: true}
+
+	if err := cmd.Run(); err != nil {
+		return call.ReplyOpenFailed(ctx, fmt.Sprintf("failed to open %s: %v", parsed, err))
+	}
+
+	return call.ReplyOpenURI(ctx)
+}
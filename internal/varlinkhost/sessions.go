@@ -0,0 +1,129 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"sync"
+	"time"
+
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/sessionkeeper"
+	"golang.org/x/sys/unix"
+)
+
+// The longest time between checks for whether the container has been idle long enough.
+const maxIdleCheckInterval = 30 * time.Second
+
+// Keeps track of the sessions entered into the container, keyed by the PID of the host process
+// that entered each one. Detached sessions are counted by asking the session keeper.
+type sessionTracker struct {
+	mutex        sync.Mutex
+	sessions     map[int]time.Time
+	idleSince    time.Time
+	keeperSocket string
+}
+
+func newSessionTracker(keeperSocket string) *sessionTracker {
+	return &sessionTracker{
+		sessions:     map[int]time.Time{},
+		idleSince:    time.Now(),
+		keeperSocket: keeperSocket,
+	}
+}
+
+func (tracker *sessionTracker) countDetached() int {
+	detached, err := sessionkeeper.List(tracker.keeperSocket)
+	if err != nil {
+		log.Debug("failed to list detached sessions:", err)
+		return 0
+	}
+
+	count := 0
+	for _, info := range detached {
+		if info.Running {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (tracker *sessionTracker) enter(pid int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.sessions[pid] = time.Now()
+}
+
+func (tracker *sessionTracker) exit(pid int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.removeLocked(pid)
+}
+
+func (tracker *sessionTracker) removeLocked(pid int) {
+	if _, ok := tracker.sessions[pid]; !ok {
+		return
+	}
+
+	delete(tracker.sessions, pid)
+	if len(tracker.sessions) == 0 {
+		tracker.idleSince = time.Now()
+	}
+}
+
+// Returns the number of active sessions, and how long the container has been idle for if there
+// are none. Sessions whose entering process died without sending an exit notification (e.g. it
+// was killed) are dropped.
+func (tracker *sessionTracker) status() (int, time.Duration) {
+	detached := tracker.countDetached()
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	for pid := range tracker.sessions {
+		if err := unix.Kill(pid, 0); err == unix.ESRCH {
+			log.Debugf("session process %d vanished", pid)
+			tracker.removeLocked(pid)
+		}
+	}
+
+	if count := len(tracker.sessions) + detached; count != 0 {
+		if len(tracker.sessions) == 0 {
+			// Don't count the time the detached sessions were running as idle.
+			tracker.idleSince = time.Now()
+		}
+
+		return count, 0
+	}
+
+	return 0, time.Since(tracker.idleSince)
+}
+
+// Calls onIdle once the container has gone the given timeout without any sessions.
+func (tracker *sessionTracker) watchIdle(timeout time.Duration, onIdle func()) {
+	interval := timeout
+	if interval > maxIdleCheckInterval {
+		interval = maxIdleCheckInterval
+	}
+
+	// Count the idle time from when the container finished starting.
+	tracker.mutex.Lock()
+	if len(tracker.sessions) == 0 {
+		tracker.idleSince = time.Now()
+	}
+	tracker.mutex.Unlock()
+
+	for {
+		time.Sleep(interval)
+
+		if count, idle := tracker.status(); count == 0 && idle >= timeout {
+			log.Infof("Container has been idle for %s, shutting it down", idle.Round(time.Second))
+			onIdle()
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSessionTracker() *sessionTracker {
+	// Nothing listens on the keeper socket, so there are never any detached sessions.
+	return newSessionTracker(filepath.Join(os.TempDir(), "nsbox-test-missing", "keeper.sock"))
+}
+
+// Returns the PID of a process that has already exited and been reaped.
+func deadPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestSessionTrackerEnterExit(t *testing.T) {
+	tracker := newTestSessionTracker()
+	pid := os.Getpid()
+
+	if count, _ := tracker.status(); count != 0 {
+		t.Fatalf("expected no sessions, got %d", count)
+	}
+
+	tracker.enter(pid)
+	tracker.enter(os.Getppid())
+
+	if count, idle := tracker.status(); count != 2 || idle != 0 {
+		t.Fatalf("expected 2 sessions and no idle time, got %d and %s", count, idle)
+	}
+
+	tracker.exit(pid)
+	if count, _ := tracker.status(); count != 1 {
+		t.Fatalf("expected 1 session, got %d", count)
+	}
+
+	// Exiting twice, or exiting a session that was never entered, is harmless.
+	tracker.exit(pid)
+	tracker.exit(-1)
+	if count, _ := tracker.status(); count != 1 {
+		t.Fatalf("expected 1 session, got %d", count)
+	}
+
+	before := time.Now()
+	tracker.exit(os.Getppid())
+	time.Sleep(10 * time.Millisecond)
+
+	count, idle := tracker.status()
+	if count != 0 {
+		t.Fatalf("expected no sessions, got %d", count)
+	}
+	if idle <= 0 || idle > time.Since(before) {
+		t.Errorf("expected idle time since the last exit, got %s", idle)
+	}
+}
+
+func TestSessionTrackerPrunesDeadProcesses(t *testing.T) {
+	tracker := newTestSessionTracker()
+
+	tracker.enter(deadPid(t))
+	tracker.enter(os.Getpid())
+
+	if count, _ := tracker.status(); count != 1 {
+		t.Fatalf("expected the dead session to be dropped, got %d sessions", count)
+	}
+
+	tracker.mutex.Lock()
+	_, alive := tracker.sessions[os.Getpid()]
+	remaining := len(tracker.sessions)
+	tracker.mutex.Unlock()
+
+	if !alive || remaining != 1 {
+		t.Errorf("expected only the live session to remain, got %d", remaining)
+	}
+
+	tracker.exit(os.Getpid())
+	tracker.enter(deadPid(t))
+
+	if count, idle := tracker.status(); count != 0 || idle <= 0 {
+		t.Errorf("expected the container to be idle, got %d sessions and %s idle", count, idle)
+	}
+}
+
+func TestSessionTrackerWatchIdle(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	tracker := newTestSessionTracker()
+	tracker.enter(os.Getpid())
+
+	idle := make(chan struct{})
+	go tracker.watchIdle(timeout, func() { close(idle) })
+
+	select {
+	case <-idle:
+		t.Fatal("idle callback ran while a session was active")
+	case <-time.After(4 * timeout):
+	}
+
+	exited := time.Now()
+	tracker.exit(os.Getpid())
+
+	select {
+	case <-idle:
+		if since := time.Since(exited); since < timeout {
+			t.Errorf("idle callback ran after %s, before the %s timeout", since, timeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle callback never ran")
+	}
+}
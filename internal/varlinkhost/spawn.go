@@ -0,0 +1,275 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/container"
+	"github.com/refi64/nsbox/internal/exitstatus"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/userdata"
+	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/refi64/nsbox/internal/varlinkpeer"
+	"golang.org/x/sys/unix"
+)
+
+// Environment variables that only make sense inside the container, so they're never passed on
+// to host commands. Anything starting with NSBOX_ is dropped too.
+var containerOnlyEnv = map[string]bool{
+	"_":                 true,
+	"HOME":              true,
+	"HOSTNAME":          true,
+	"LD_LIBRARY_PATH":   true,
+	"LD_PRELOAD":        true,
+	"LOGNAME":           true,
+	"MAIL":              true,
+	"NOTIFY_SOCKET":     true,
+	"OLDPWD":            true,
+	"PATH":              true,
+	"PWD":               true,
+	"SHELL":             true,
+	"SHLVL":             true,
+	"USER":              true,
+	"XDG_RUNTIME_DIR":   true,
+	"container":         true,
+	"container_host_id": true,
+	"container_uuid":    true,
+}
+
+type spawned struct {
+	uid     int
+	process *os.Process
+}
+
+// Keeps track of the commands spawned on the host, so they can be signaled.
+type spawnTracker struct {
+	mutex  sync.Mutex
+	spawns map[int64]*spawned
+	lastId int64
+}
+
+func newSpawnTracker() *spawnTracker {
+	return &spawnTracker{spawns: map[int64]*spawned{}}
+}
+
+func (tracker *spawnTracker) register(uid int, process *os.Process) int64 {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.lastId++
+	tracker.spawns[tracker.lastId] = &spawned{uid: uid, process: process}
+	return tracker.lastId
+}
+
+func (tracker *spawnTracker) unregister(id int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	delete(tracker.spawns, id)
+}
+
+func (tracker *spawnTracker) get(id int64) *spawned {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return tracker.spawns[id]
+}
+
+// Builds a host command's environment out of the one it was spawned with.
+func spawnEnviron(usrdata *userdata.Userdata, environ []string) []string {
+	result := usrdata.HostEnviron()
+
+	for _, env := range environ {
+		if !strings.Contains(env, "=") {
+			continue
+		}
+
+		if name, _ := userdata.SplitEnv(env); containerOnlyEnv[name] || strings.HasPrefix(name, "NSBOX_") {
+			continue
+		}
+
+		result = append(result, env)
+	}
+
+	return result
+}
+
+// Finds the command in the host's PATH, since exec.LookPath would use nsboxd's.
+func lookHostPath(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+
+	for _, dir := range filepath.SplitList(userdata.DefaultHostPath) {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+
+	return "", errors.Errorf("%s: command not found", name)
+}
+
+// Only the container's owner, or root inside it (which is root on the host anyway), can run
+// things on the host.
+func (host *VarlinkHost) isOwnerOrRoot(peer *varlinkpeer.Conn) bool {
+	uid, _ := host.usrdata.NumericIds()
+	peerUid := int(peer.Cred().Uid)
+	return peerUid == uid || peerUid == 0
+}
+
+func (host *VarlinkHost) Spawn(ctx context.Context, call devnsbox.VarlinkCall, command []string, environ []string, cwd string, tty bool) error {
+	log.Debugf("received Spawn(%v, %s, %t)", command, cwd, tty)
+
+	peer, err := varlinkpeer.FromCall(call.Call)
+	if err != nil {
+		return err
+	}
+
+	files := peer.TakeFiles()
+	defer varlinkpeer.CloseFiles(files)
+
+	// Checked first, so other users can't even find out whether host-spawn is turned on.
+	if !host.isOwnerOrRoot(peer) {
+		return call.ReplySpawnFailed(ctx, "only the container's owner can spawn host commands")
+	}
+
+	// Re-read the config, so toggling host-spawn applies without restarting the container.
+	ct, err := container.OpenPath(host.container.Path, host.container.Name)
+	if err != nil {
+		return err
+	}
+
+	if !ct.Config.HostSpawn {
+		return call.ReplySpawnDisabled(ctx)
+	}
+
+	if len(files) != 3 {
+		return call.ReplySpawnFailed(ctx, "stdin, stdout, and stderr must be sent with the call")
+	}
+
+	if len(command) == 0 {
+		return call.ReplySpawnFailed(ctx, "expected a command")
+	}
+
+	if tty && !call.WantsMore() {
+		return call.ReplySpawnFailed(ctx, "spawning with a tty requires more")
+	}
+
+	path, err := lookHostPath(command[0])
+	if err != nil {
+		return call.ReplySpawnFailed(ctx, err.Error())
+	}
+
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Args[0] = command[0]
+	cmd.Env = spawnEnviron(host.usrdata, environ)
+	cmd.SysProcAttr = &unix.SysProcAttr{Credential: host.usrdata.Credential()}
+
+	cmd.Dir = ct.HostPath(host.usrdata, cwd)
+	if cmd.Dir == "" {
+		log.Debugf("%s isn't shared with the host, using home instead", cwd)
+		cmd.Dir = host.usrdata.User.HomeDir
+	}
+
+	var master, slave *os.File
+	if tty {
+		master, slave, err = pty.Open()
+		if err != nil {
+			return errors.Wrap(err, "failed to open pty")
+		}
+
+		if err := slave.Chown(int(cmd.SysProcAttr.Credential.Uid), -1); err != nil {
+			log.Debug("failed to chown pty:", err)
+		}
+
+		pty.InheritSize(files[0], master)
+
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		cmd.Stderr = slave
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+	} else {
+		cmd.Stdin = files[0]
+		cmd.Stdout = files[1]
+		cmd.Stderr = files[2]
+		// Signals are sent to the entire group, like a terminal would.
+		cmd.SysProcAttr.Setpgid = true
+	}
+
+	err = cmd.Start()
+
+	// Only the command needs these now.
+	varlinkpeer.CloseFiles(files)
+	files = nil
+	if slave != nil {
+		slave.Close()
+	}
+
+	if err != nil {
+		if master != nil {
+			master.Close()
+		}
+
+		return call.ReplySpawnFailed(ctx, err.Error())
+	}
+
+	id := host.spawns.register(int(peer.Cred().Uid), cmd.Process)
+	defer host.spawns.unregister(id)
+
+	log.Debugf("spawned %d: %v", id, cmd.Args)
+
+	if call.WantsMore() {
+		if master != nil {
+			peer.SendFiles(master)
+		}
+
+		call.Continues = true
+		if err := call.ReplySpawn(ctx, id, 0); err != nil {
+			log.Debug("failed to send spawned command id:", err)
+		}
+		call.Continues = false
+	}
+
+	if master != nil {
+		// The caller has its own copy now.
+		master.Close()
+	}
+
+	status := exitstatus.FromWaitError(cmd.Wait())
+	return call.ReplySpawn(ctx, id, int64(status))
+}
+
+func (host *VarlinkHost) SignalSpawned(ctx context.Context, call devnsbox.VarlinkCall, id int64, signal int64) error {
+	log.Debugf("received SignalSpawned(%d, %d)", id, signal)
+
+	peer, err := varlinkpeer.FromCall(call.Call)
+	if err != nil {
+		return err
+	}
+
+	if signal <= 0 || signal >= 65 {
+		return call.ReplySpawnFailed(ctx, fmt.Sprintf("invalid signal: %d", signal))
+	}
+
+	// The command may well have just exited, so unknown ids are ignored.
+	if spawn := host.spawns.get(id); spawn != nil && spawn.uid == int(peer.Cred().Uid) {
+		if err := unix.Kill(-spawn.process.Pid, unix.Signal(signal)); err != nil && err != unix.ESRCH {
+			log.Debugf("failed to signal spawned command %d: %v", id, err)
+		}
+	}
+
+	return call.ReplySignalSpawned(ctx)
+}
@@ -0,0 +1,75 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkhost
+
+import (
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/refi64/nsbox/internal/userdata"
+)
+
+func TestSpawnEnviron(t *testing.T) {
+	usrdata := &userdata.Userdata{
+		User:  &user.User{Uid: "1000", Gid: "1000", Username: "user", HomeDir: "/home/user"},
+		Shell: "/bin/bash",
+	}
+
+	environ := []string{
+		"TERM=xterm-256color",
+		"LANG=C.UTF-8",
+		"EMPTY=",
+		"invalid",
+		"HOME=/container/home",
+		"PATH=/container/bin",
+		"LD_PRELOAD=/container/lib/evil.so",
+		"NSBOX_CONTAINER=test",
+		"container=nsbox",
+	}
+
+	expected := append(usrdata.HostEnviron(), "TERM=xterm-256color", "LANG=C.UTF-8", "EMPTY=")
+	if result := spawnEnviron(usrdata, environ); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestLookHostPath(t *testing.T) {
+	// Paths are used as-is, even if they don't exist on the host.
+	for _, name := range []string{"/nonexistent/bin/command", "./command", "dir/command"} {
+		if path, err := lookHostPath(name); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if path != name {
+			t.Errorf("%s: expected it to be kept as-is, got %s", name, path)
+		}
+	}
+
+	path, err := lookHostPath("sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(path) != "sh" || !filepath.IsAbs(path) {
+		t.Errorf("expected sh to be found in the host's PATH, got %s", path)
+	}
+
+	inPath := false
+	for _, dir := range filepath.SplitList(userdata.DefaultHostPath) {
+		if filepath.Dir(path) == dir {
+			inPath = true
+		}
+	}
+
+	if !inPath {
+		t.Errorf("expected %s to come from %s", path, userdata.DefaultHostPath)
+	}
+
+	if _, err := lookHostPath("nsbox-nonexistent-command"); err == nil ||
+		!strings.Contains(err.Error(), "command not found") {
+		t.Errorf("expected a missing command to fail, got %v", err)
+	}
+}
@@ -6,34 +6,129 @@ package varlinkhost
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/pkg/errors"
 	"github.com/refi64/nsbox/internal/container"
 	"github.com/refi64/nsbox/internal/integration"
 	"github.com/refi64/nsbox/internal/log"
+	"github.com/refi64/nsbox/internal/paths"
+	"github.com/refi64/nsbox/internal/userdata"
 	devnsbox "github.com/refi64/nsbox/internal/varlink"
+	"github.com/refi64/nsbox/internal/varlinkpeer"
 )
 
 type VarlinkHost struct {
 	devnsbox.VarlinkInterface
 
 	container *container.Container
+	usrdata   *userdata.Userdata
+	onStart   func()
+	onIdle    func()
+	sessions  *sessionTracker
+	spawns    *spawnTracker
+
+	hookFailuresMutex sync.Mutex
+}
+
+// The host service socket is open to every user in the container, so the methods that only
+// nsbox itself should call are restricted to root.
+func requireRoot(call devnsbox.VarlinkCall) error {
+	peer, err := varlinkpeer.FromCall(call.Call)
+	if err != nil {
+		return err
+	}
+
+	if peer.Cred().Uid != 0 {
+		return errors.Errorf("process %d is not allowed to call %s", peer.Cred().Pid, call.In.Method)
+	}
+
+	return nil
+}
+
+// Like requireRoot, but the container's owner is allowed too.
+func (host *VarlinkHost) requireOwnerOrRoot(call devnsbox.VarlinkCall) error {
+	peer, err := varlinkpeer.FromCall(call.Call)
+	if err != nil {
+		return err
+	}
+
+	if !host.isOwnerOrRoot(peer) {
+		return errors.Errorf("process %d is not allowed to call %s", peer.Cred().Pid, call.In.Method)
+	}
+
+	return nil
+}
+
+func (host *VarlinkHost) Handshake(ctx context.Context, call devnsbox.VarlinkCall, clientProtocol int64) error {
+	log.Debugf("received Handshake(%d)", clientProtocol)
+
+	if clientProtocol > devnsbox.ProtocolVersion {
+		log.Infof("Container client speaks protocol %d, but the host only speaks %d; update nsbox",
+			clientProtocol, devnsbox.ProtocolVersion)
+	}
+
+	return call.ReplyHandshake(ctx, devnsbox.ProtocolVersion, devnsbox.Capabilities)
 }
 
 func (host *VarlinkHost) NotifyStart(ctx context.Context, call devnsbox.VarlinkCall) error {
 	log.Debug("received NotifyStart()")
 
+	if err := requireRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	if timeout := time.Duration(host.container.Config.IdleTimeout); timeout != 0 && host.onIdle != nil {
+		go host.sessions.watchIdle(timeout, host.onIdle)
+	}
+
 	if _, err := daemon.SdNotify(true, daemon.SdNotifyReady); err != nil {
 		log.Alert("notifying systemd of start", err)
 		return err
 	}
 
-	return call.ReplyNotifyStart(ctx)
+	if err := call.ReplyNotifyStart(ctx); err != nil {
+		return err
+	}
+
+	// Run in the background, so a slow or hung hook can't hold up the container's startup.
+	if host.onStart != nil {
+		go host.onStart()
+	}
+
+	return nil
+}
+
+func (host *VarlinkHost) NotifyStartFailed(ctx context.Context, call devnsbox.VarlinkCall, stage string, message string, initLog string) error {
+	log.Debugf("received NotifyStartFailed(%s, %s)", stage, message)
+
+	if err := requireRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	log.Alertf("Container init failed during %s: %s", stage, message)
+
+	failure := &container.StartFailure{Stage: stage, Message: message, Log: initLog}
+	if err := host.container.SaveStartFailure(failure); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	return call.ReplyNotifyStartFailed(ctx)
 }
 
 func (host *VarlinkHost) NotifyReloadExports(ctx context.Context, call devnsbox.VarlinkCall) error {
 	log.Debug("received NotifyReloadExports()")
 
+	if err := host.requireOwnerOrRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
 	if err := integration.UpdateDesktopFiles(host.container); err != nil {
 		log.Alert("updating desktop files", err)
 		return err
@@ -42,7 +137,71 @@ func (host *VarlinkHost) NotifyReloadExports(ctx context.Context, call devnsbox.
 	return call.ReplyNotifyReloadExports(ctx)
 }
 
-func New(ct *container.Container) *devnsbox.VarlinkInterface {
-	host := VarlinkHost{container: ct}
+func (host *VarlinkHost) NotifyHookFailed(ctx context.Context, call devnsbox.VarlinkCall, hook string, path string, status int64) error {
+	log.Debugf("received NotifyHookFailed(%s, %s, %d)", hook, path, status)
+
+	if err := requireRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	log.Alertf("Container %s hook %s failed with status %d", hook, path, status)
+
+	// Hooks can fail concurrently, e.g. when entering several sessions at once.
+	host.hookFailuresMutex.Lock()
+	defer host.hookFailuresMutex.Unlock()
+
+	failure := container.HookFailure{Hook: hook, Path: path, Status: status, Time: time.Now()}
+	if err := host.container.AddHookFailure(failure); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	return call.ReplyNotifyHookFailed(ctx)
+}
+
+func (host *VarlinkHost) NotifySessionEnter(ctx context.Context, call devnsbox.VarlinkCall, pid int64) error {
+	log.Debugf("received NotifySessionEnter(%d)", pid)
+
+	if err := requireRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	host.sessions.enter(int(pid))
+	return call.ReplyNotifySessionEnter(ctx)
+}
+
+func (host *VarlinkHost) NotifySessionExit(ctx context.Context, call devnsbox.VarlinkCall, pid int64) error {
+	log.Debugf("received NotifySessionExit(%d)", pid)
+
+	if err := requireRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	host.sessions.exit(int(pid))
+	return call.ReplyNotifySessionExit(ctx)
+}
+
+func (host *VarlinkHost) GetSessions(ctx context.Context, call devnsbox.VarlinkCall) error {
+	log.Debug("received GetSessions()")
+
+	if err := host.requireOwnerOrRoot(call); err != nil {
+		log.Alert(err)
+		return err
+	}
+
+	count, idle := host.sessions.status()
+	return call.ReplyGetSessions(ctx, int64(count), int64(idle/time.Second))
+}
+
+// Creates a new varlink host interface for the container. onStart, if non-nil, is called in the
+// background once the container has finished initializing and systemd has been notified. onIdle, if non-nil, is
+// called once the container has gone its IdleTimeout without any sessions.
+func New(ct *container.Container, usrdata *userdata.Userdata, onStart, onIdle func()) *devnsbox.VarlinkInterface {
+	keeperSocket := ct.StorageChild(paths.InContainerPrivPath, paths.SessionKeeperSocketName)
+	host := VarlinkHost{container: ct, usrdata: usrdata, onStart: onStart, onIdle: onIdle,
+		sessions: newSessionTracker(keeperSocket), spawns: newSpawnTracker()}
 	return devnsbox.VarlinkNew(&host)
 }
@@ -0,0 +1,88 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package varlinkpeer
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/varlink/go/varlink"
+)
+
+// A varlink client that can send files along with its calls, which the generated clients
+// can't do.
+type Client struct {
+	*Conn
+}
+
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := NewConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Client{peer}, nil
+}
+
+// Calls the method, sending the files along with it. Replies are read via Receive.
+func (client *Client) Send(method string, parameters interface{}, more bool, files ...*os.File) error {
+	request, err := json.Marshal(struct {
+		Method     string      `json:"method"`
+		Parameters interface{} `json:"parameters,omitempty"`
+		More       bool        `json:"more,omitempty"`
+	}{
+		Method:     method,
+		Parameters: parameters,
+		More:       more,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal call")
+	}
+
+	client.SendFiles(files...)
+	_, err = client.Write(context.Background(), append(request, 0))
+	return err
+}
+
+// Reads the next reply into out, returning whether more replies will follow. Error replies are
+// returned as a *varlink.Error, to be passed to the interface's Dispatch_Error. Any files sent
+// with the reply can be picked up via TakeFiles.
+func (client *Client) Receive(out interface{}) (continues bool, err error) {
+	data, err := client.ReadBytes(context.Background(), 0)
+	if err != nil {
+		return false, err
+	}
+
+	var reply struct {
+		Parameters *json.RawMessage `json:"parameters"`
+		Continues  bool             `json:"continues"`
+		Error      string           `json:"error"`
+	}
+
+	if err := json.Unmarshal(data[:len(data)-1], &reply); err != nil {
+		return false, errors.Wrap(err, "failed to parse reply")
+	}
+
+	if reply.Error != "" {
+		return false, &varlink.Error{Name: reply.Error, Parameters: reply.Parameters}
+	}
+
+	if reply.Parameters != nil && out != nil {
+		if err := json.Unmarshal(*reply.Parameters, out); err != nil {
+			return false, errors.Wrap(err, "failed to parse reply")
+		}
+	}
+
+	return reply.Continues, nil
+}
@@ -0,0 +1,233 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Package varlinkpeer lets varlink services and clients see each other's credentials and pass
+// files alongside messages, neither of which the varlink library exposes.
+package varlinkpeer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/refi64/nsbox/internal/log"
+	"github.com/varlink/go/varlink"
+	"golang.org/x/sys/unix"
+)
+
+// The most files that can be sent along with a single message.
+const maxFilesPerMessage = 16
+
+// A varlink connection that knows who is on the other end, and keeps any files they sent
+// alongside their messages so method calls can pick them up.
+type Conn struct {
+	conn     *net.UnixConn
+	cred     *unix.Ucred
+	buffer   []byte
+	files    []*os.File
+	outgoing []*os.File
+}
+
+func NewConn(conn *net.UnixConn) (*Conn, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get raw connection")
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get peer credentials")
+	}
+
+	return &Conn{conn: conn, cred: cred}, nil
+}
+
+// Returns the credentials of the process that created the other end of the connection.
+func (peer *Conn) Cred() *unix.Ucred {
+	return peer.cred
+}
+
+// Reads the next chunk of data, along with any files sent with it.
+func (peer *Conn) fill() error {
+	data := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(maxFilesPerMessage*4))
+
+	n, oobn, flags, _, err := peer.conn.ReadMsgUnix(data, oob)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return errors.Wrap(err, "failed to parse control messages")
+	}
+
+	for _, msg := range msgs {
+		fds, err := unix.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			unix.CloseOnExec(fd)
+			peer.files = append(peer.files, os.NewFile(uintptr(fd), "peer"))
+		}
+	}
+
+	if flags&unix.MSG_CTRUNC != 0 {
+		return errors.New("too many files were sent")
+	}
+
+	if n == 0 {
+		return io.EOF
+	}
+
+	peer.buffer = append(peer.buffer, data[:n]...)
+	return nil
+}
+
+func (peer *Conn) Read(ctx context.Context, p []byte) (int, error) {
+	if len(peer.buffer) == 0 {
+		if err := peer.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, peer.buffer)
+	peer.buffer = peer.buffer[n:]
+	return n, nil
+}
+
+func (peer *Conn) ReadBytes(ctx context.Context, delim byte) ([]byte, error) {
+	for {
+		if idx := bytes.IndexByte(peer.buffer, delim); idx != -1 {
+			data := append([]byte{}, peer.buffer[:idx+1]...)
+			peer.buffer = peer.buffer[idx+1:]
+			return data, nil
+		}
+
+		if err := peer.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (peer *Conn) Write(ctx context.Context, p []byte) (int, error) {
+	if len(peer.outgoing) == 0 {
+		return peer.conn.Write(p)
+	}
+
+	fds := make([]int, len(peer.outgoing))
+	for i, file := range peer.outgoing {
+		fds[i] = int(file.Fd())
+	}
+
+	n, _, err := peer.conn.WriteMsgUnix(p, unix.UnixRights(fds...), nil)
+	// The files are only borrowed, so the caller still owns them.
+	peer.outgoing = nil
+	return n, err
+}
+
+// Sends the files along with the next message written, e.g. the next reply to a call. The
+// files must stay open until then.
+func (peer *Conn) SendFiles(files ...*os.File) {
+	peer.outgoing = append(peer.outgoing, files...)
+}
+
+// Returns every file received so far, which the caller is now responsible for closing.
+func (peer *Conn) TakeFiles() []*os.File {
+	files := peer.files
+	peer.files = nil
+	return files
+}
+
+func CloseFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+func (peer *Conn) Close() error {
+	CloseFiles(peer.TakeFiles())
+	return peer.conn.Close()
+}
+
+// Returns the peer connection a method call came in on.
+func FromCall(call varlink.Call) (*Conn, error) {
+	peer, ok := call.Conn.(*Conn)
+	if !ok {
+		return nil, errors.New("unexpected varlink connection type")
+	}
+
+	return peer, nil
+}
+
+func serveConnection(service *varlink.Service, conn *net.UnixConn) {
+	peer, err := NewConn(conn)
+	if err != nil {
+		log.Alert(err)
+		conn.Close()
+		return
+	}
+
+	defer peer.Close()
+
+	ctx := context.Background()
+	for {
+		request, err := peer.ReadBytes(ctx, 0)
+		if err != nil {
+			if err != io.EOF {
+				log.Debug("failed to read varlink request:", err)
+			}
+
+			return
+		}
+
+		if err := service.HandleMessage(ctx, peer, request[:len(request)-1]); err != nil {
+			log.Debug("failed to handle varlink request:", err)
+			return
+		}
+	}
+}
+
+// Serves the varlink service on the listener in the background. Connections are accepted here,
+// instead of by the varlink service, so method calls can get at the peer via FromCall.
+func Serve(service *varlink.Service, listener *net.UnixListener) {
+	go func() {
+		for {
+			conn, err := listener.AcceptUnix()
+			if err != nil {
+				// Closing the listener is how serving is stopped.
+				if !isClosedError(err) {
+					log.Alert("failed to accept varlink connection:", err)
+				}
+
+				return
+			}
+
+			go serveConnection(service, conn)
+		}
+	}()
+}
+
+func isClosedError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+
+	// net.ErrClosed only exists as of Go 1.16.
+	return err != nil && err.Error() == "use of closed network connection"
+}
@@ -2,10 +2,15 @@
 # License, v. 2.0. If a copy of the MPL was not distributed with this
 # file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
-# Updates XDG_DATA_DIRS with nsbox container exports.
+# Updates XDG_DATA_DIRS and PATH with nsbox container exports.
 
 for dir in @STATE_DIR/nsbox/$USER/inventory/*; do
-  [ -d "$dir/exports/share" ] || continue
+  if [ -d "$dir/exports/share" ]; then
+    export XDG_DATA_DIRS="$XDG_DATA_DIRS:$dir/exports/share/"
+  fi
 
-  export XDG_DATA_DIRS="$XDG_DATA_DIRS:$dir/exports/share/"
+  # Appended, so host commands always take precedence over exported ones.
+  if [ -d "$dir/exports/bin" ]; then
+    export PATH="$PATH:$dir/exports/bin"
+  fi
 done
@@ -28,6 +28,7 @@ Release: 1%{?dist}
 Summary: A multi-purpose, nspawn-powered container manager
 License: MPL-2.0
 URL: https://nsbox.dev/
+BuildRequires: firewalld-filesystem
 BuildRequires: gcc
 BuildRequires: gn
 BuildRequires: go-rpm-macros
@@ -119,9 +120,12 @@ ninja -C out
 %install
 mkdir -p %{buildroot}/%{_prefix}
 cp -r out/install/%{_sysconfdir} %{buildroot}
-cp -r out/install/{%{relbindir},%{rellibexecdir},%{reldatadir}} %{buildroot}/%{_prefix}
+cp -r out/install/{%{relbindir},%{rellibexecdir},%{reldatadir},lib} %{buildroot}/%{_prefix}
 chmod -R g-w %{buildroot}
 
+%post
+%firewalld_reload
+
 %pre selinux
 %selinux_relabel_pre
 
@@ -142,7 +146,9 @@ fi
 %{_libexecdir}/%{name}/nsboxd
 %{_libexecdir}/%{name}/nsbox-invoker
 %{_libexecdir}/%{name}/nsbox-host
+%{_libexecdir}/%{name}/nsbox-manager
 %{_datadir}/%{name}/data/getty-override.conf
+%{_datadir}/%{name}/data/host0-llmnr.conf
 %{_datadir}/%{name}/data/wants-networkd.conf
 %{_datadir}/%{name}/data/nsbox-container.target
 %{_datadir}/%{name}/data/nsbox-init.service
@@ -150,11 +156,16 @@ fi
 %{_datadir}/%{name}/data/scripts/nsbox-enter-run.sh
 %{_datadir}/%{name}/data/scripts/nsbox-enter-setup.sh
 %{_datadir}/%{name}/data/scripts/nsbox-init.sh
+%{_datadir}/%{name}/data/scripts/nsbox-run-hooks.sh
 %{_datadir}/%{name}/images/*
 %{_datadir}/%{name}/release/VERSION
 %{_datadir}/%{name}/release/BRANCH
+%{_prefix}/lib/firewalld/zones/%{name}.xml
 %{_datadir}/polkit-1/actions/@RDNS_NAME.policy
 %{_datadir}/polkit-1/rules.d/@RDNS_NAME.rules
+%{_datadir}/dbus-1/system.d/@RDNS_NAME.Manager.conf
+%{_datadir}/dbus-1/system-services/@RDNS_NAME.Manager.service
+%{_unitdir}/%{name}-manager.service
 
 %files selinux
 %{_datadir}/selinux/packages/%{name}.pp.bz2